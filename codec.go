@@ -0,0 +1,130 @@
+package pubsub_go
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeAttribute is the message attribute that records the content type
+// of the codec used to marshal the message.
+const ContentTypeAttribute = "Content-Type"
+
+// Codec marshals a message into the data that is published to a topic and
+// unmarshals received data back into a value.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec marshals messages as JSON.
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgPackCodec marshals messages as MessagePack.
+	MsgPackCodec Codec = msgPackCodec{}
+
+	// ProtobufCodec marshals messages that implement proto.Message using the
+	// Protocol Buffers binary wire format.
+	ProtobufCodec Codec = protobufCodec{}
+
+	// RawCodec publishes []byte and string messages as is.
+	RawCodec Codec = rawCodec{}
+)
+
+// CodecFor returns the built-in codec for the content type, which can be used
+// to decode a received message based on its Content-Type attribute.
+func CodecFor(contentType string) (Codec, bool) {
+	for _, c := range []Codec{JSONCodec, MsgPackCodec, ProtobufCodec, RawCodec} {
+		if c.ContentType() == contentType {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgPackCodec struct{}
+
+func (msgPackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot marshal %T, it does not implement proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec cannot unmarshal into %T, it does not implement proto.Message", v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch d := v.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return nil, fmt.Errorf("raw codec cannot marshal %T, it must be a []byte or string", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch d := v.(type) {
+	case *[]byte:
+		*d = data
+	case *string:
+		*d = string(data)
+	default:
+		return fmt.Errorf("raw codec cannot unmarshal into %T, it must be a *[]byte or *string", v)
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ go 1.19
 
 require (
 	cloud.google.com/go/pubsub v1.30.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	google.golang.org/api v0.118.0
+	google.golang.org/protobuf v1.30.0
 )
 
 require (
@@ -18,6 +20,7 @@ require (
 	github.com/google/s2a-go v0.1.1 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.2.3 // indirect
 	github.com/googleapis/gax-go/v2 v2.8.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/crypto v0.8.0 // indirect
 	golang.org/x/net v0.9.0 // indirect
@@ -28,5 +31,4 @@ require (
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 	google.golang.org/grpc v1.54.0 // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
 )
@@ -1,8 +1,13 @@
 package pubsub_go
 
-// Message holds the data needed for publishing a message to PubSub.
+// Message holds the data needed for publishing a message to PubSub. When Codec
+// is nil, the Codec from the Config is used. Messages with the same OrderingKey
+// are delivered in the order they were published to subscriptions that have
+// EnableMessageOrdering set.
 type Message struct {
-	Attributes map[string]string
-	Message    interface{}
-	Topic      string
+	Attributes  map[string]string
+	Codec       Codec
+	Message     interface{}
+	OrderingKey string
+	Topic       string
 }
@@ -3,8 +3,8 @@ package pubsub_go
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -15,10 +15,13 @@ import (
 // publishing messages to a topic and receiving messages from a subscription.
 type PubSub struct {
 	client   *pubsub.Client
+	mu       sync.Mutex
 	settings settings
+	topics   map[string]*pubsub.Topic
 }
 
 type settings struct {
+	codec   Codec
 	publish PublishSettings
 	receive ReceiveSettings
 }
@@ -26,6 +29,7 @@ type settings struct {
 // Config provides the information needed to securely connect to Google Cloud's PubSub
 // and to configure any publishing and subscription options.
 type Config struct {
+	Codec                  Codec
 	IsLocal                bool
 	ProjectID              string
 	PublishSettings        PublishSettings
@@ -68,12 +72,29 @@ func NewPubSub(c Config) (*PubSub, error) {
 	return &PubSub{
 		client: client,
 		settings: settings{
+			codec:   c.Codec,
 			publish: c.PublishSettings,
 			receive: c.ReceiveSettings,
 		},
+		topics: map[string]*pubsub.Topic{},
 	}, nil
 }
 
+// Close stops the publishers of the topics that messages have been published
+// to, which sends any messages they still hold, and then closes the client.
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	ts := ps.topics
+	ps.topics = map[string]*pubsub.Topic{}
+	ps.mu.Unlock()
+
+	for _, t := range ts {
+		t.Stop()
+	}
+
+	return ps.client.Close()
+}
+
 // Create Subscriptions for a Topic based on a map of Subscription Name and Filter
 func (ps *PubSub) CreateSubscriptions(tid string, sids map[string]string, cfg *SubscriptionConfig) error {
 	ctx := context.Background()
@@ -135,33 +156,73 @@ func (ps *PubSub) CreateTopic(tid string, cfg *TopicConfig) error {
 }
 
 // Publish sends a message to a topic along with any attributes that were provided.
+// The message is marshalled with the Message's Codec if set, otherwise the Config's
+// Codec, and as JSON if neither is set. The codec's content type is recorded in the
+// Content-Type attribute. The message's OrderingKey, if set, is published with it.
 func (ps *PubSub) Publish(m Message) error {
-	topic := ps.client.Topic(m.Topic)
-	topic.PublishSettings = ps.settings.publish.Settings
+	topic := ps.topic(m.Topic)
 
-	data, err := json.Marshal(m.Message)
+	codec := m.Codec
+	if codec == nil {
+		codec = ps.settings.codec
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	data, err := codec.Marshal(m.Message)
 	if err != nil {
 		return err
 	}
 
 	if m.Attributes != nil {
 		m.Attributes["OriginatedAt"] = fmt.Sprintf("%v", time.Now().Unix())
+	} else {
+		m.Attributes = map[string]string{}
+	}
+
+	if _, ok := m.Attributes[ContentTypeAttribute]; !ok {
+		m.Attributes[ContentTypeAttribute] = codec.ContentType()
 	}
 
 	ctx := context.Background()
 	result := topic.Publish(ctx, &pubsub.Message{
-		Data:       data,
-		Attributes: m.Attributes,
+		Data:        data,
+		Attributes:  m.Attributes,
+		OrderingKey: m.OrderingKey,
 	})
 
 	_, err = result.Get(ctx)
 	if err != nil {
+		// a failure pauses the ordering key until it is resumed
+		if m.OrderingKey != "" {
+			topic.ResumePublish(m.OrderingKey)
+		}
 		return err
 	}
 
 	return nil
 }
 
+// topic returns the publisher for the topic, which is shared by every call to
+// Publish so that a failed ordering key is resumed on the publisher that paused
+// it. Ordering is always enabled, as it only applies to messages with a key.
+func (ps *PubSub) topic(tid string) *pubsub.Topic {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if t, ok := ps.topics[tid]; ok {
+		return t
+	}
+
+	t := ps.client.Topic(tid)
+	t.PublishSettings = ps.settings.publish.Settings
+	t.EnableMessageOrdering = true
+	ps.topics[tid] = t
+
+	return t
+}
+
 // Receive subscribes to a topic via the subscription id and passes messages back to the caller
 // through the channel.
 func (ps *PubSub) Receive(subscription string, messages chan<- *pubsub.Message) error {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// the attributes Google Cloud Pub/Sub adds to dead lettered messages
+const (
+	deadLetterAttributePrefix   = "CloudPubSubDeadLetter"
+	deliveryCountAttribute      = "CloudPubSubDeadLetterSourceDeliveryCount"
+	sourceSubscriptionAttribute = "CloudPubSubDeadLetterSourceSubscription"
+)
+
+// redrivenAtAttribute records when a message was redriven, in Unix seconds.
+const redrivenAtAttribute = "RedrivenAt"
+
+func runDLQ(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("dlq requires a subcommand: list or redrive")
+	}
+
+	switch args[0] {
+	case "list":
+		return dlqList(ctx, ps, args[1:], out)
+	case "redrive":
+		return dlqRedrive(ctx, ps, args[1:], out)
+	default:
+		return fmt.Errorf("unknown dlq subcommand %q", args[0])
+	}
+}
+
+// dlqList prints the selected messages of a dead letter subscription and
+// leaves them in it.
+func dlqList(ctx context.Context, ps *psb.PubSub, args []string, out io.Writer) error {
+	return peek(ctx, ps, "dlq list", args, out)
+}
+
+// dlqRedrive publishes the selected messages of a dead letter subscription to
+// the topic they were dead lettered from and acks them. Messages are forwarded
+// as they were received, so encrypted and compressed data is left as is.
+// Messages that are not selected are left in the subscription.
+func dlqRedrive(ctx context.Context, ps *psb.PubSub, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("dlq redrive", flag.ContinueOnError)
+
+	c := collector{raw: true}
+	c.register(fs)
+
+	var sel selector
+	sel.register(fs)
+
+	topic := fs.String("topic", "", "the topic to publish to, instead of the topic of the subscription the messages were dead lettered from")
+	dry := fs.Bool("dry-run", false, "print the messages that would be redriven without publishing them")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := sel.compile(); err != nil {
+		return err
+	}
+
+	msgs, err := c.collect(ctx, ps)
+	if err != nil {
+		return err
+	}
+
+	r := &redriver{
+		ps:     ps,
+		topic:  *topic,
+		topics: make(map[string]string),
+	}
+
+	n := 0
+	for i, m := range msgs {
+		if !sel.match(m) {
+			m.Nack()
+			continue
+		}
+
+		tid, err := r.topicFor(ctx, m)
+		if err != nil {
+			nackAll(msgs[i:])
+			return err
+		}
+
+		if *dry {
+			fmt.Fprintf(out, "would redrive %s to %s\n", m.ID, tid)
+			m.Nack()
+			n++
+			continue
+		}
+
+		if err := ps.Publish(tid, m.Data, redriveAttributes(m.Attributes)); err != nil {
+			nackAll(msgs[i:])
+			return fmt.Errorf("redriving %s to %s: %w", m.ID, tid, err)
+		}
+
+		m.Ack()
+		fmt.Fprintf(out, "redrove %s to %s\n", m.ID, tid)
+		n++
+	}
+
+	fmt.Fprintf(out, "%d of %d messages redriven\n", n, len(msgs))
+
+	return nil
+}
+
+// redriver resolves the topic each dead lettered message is redriven to.
+type redriver struct {
+	ps     *psb.PubSub
+	topic  string
+	topics map[string]string
+}
+
+// topicFor returns the topic of the subscription the message was dead
+// lettered from, unless a topic was provided.
+func (r *redriver) topicFor(ctx context.Context, m *psb.Message) (string, error) {
+	if r.topic != "" {
+		return r.topic, nil
+	}
+
+	src, ok := m.Attributes[sourceSubscriptionAttribute]
+	if !ok {
+		return "", fmt.Errorf("message %s has no %s attribute, provide the topic with -topic", m.ID, sourceSubscriptionAttribute)
+	}
+	sid := src[strings.LastIndex(src, "/")+1:]
+
+	if tid, ok := r.topics[sid]; ok {
+		return tid, nil
+	}
+
+	cfg, err := r.ps.Backend().Subscription(sid).Config(ctx)
+	if err != nil {
+		return "", fmt.Errorf("finding the topic of subscription %s: %w", sid, err)
+	}
+
+	r.topics[sid] = cfg.TopicID
+	return cfg.TopicID, nil
+}
+
+// redriveAttributes returns the attributes of a dead lettered message without
+// the ones added by dead lettering, and with the RedrivenAt attribute set.
+func redriveAttributes(attrs map[string]string) map[string]string {
+	rattrs := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		if !strings.HasPrefix(k, deadLetterAttributePrefix) {
+			rattrs[k] = v
+		}
+	}
+
+	rattrs[redrivenAtAttribute] = fmt.Sprintf("%v", time.Now().Unix())
+
+	return rattrs
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// newDLQPubSub returns a PubSub with an orders topic whose subscription dead
+// letters to orders-dlq, and a message for each region in the dead letter
+// subscription.
+func newDLQPubSub(t *testing.T, regions ...string) *psb.PubSub {
+	t.Helper()
+
+	ps, err := psb.NewPubSub(context.Background(), psb.Options("test-project").SetBackend(psb.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+
+	if err := ps.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscriptionWithOptions("orders", "orders-sub", "", psb.WithDeadLetter("orders-dlq", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range regions {
+		err := ps.Publish("orders-dlq", []byte("order from "+r), map[string]string{
+			"OriginatedAt":              "1700000000",
+			"region":                    r,
+			deliveryCountAttribute:      "5",
+			sourceSubscriptionAttribute: "projects/test-project/subscriptions/orders-sub",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return ps
+}
+
+func TestDLQList(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			"should list every message",
+			nil,
+			[]string{"order from CA", "order from NY", "2 of 2 messages selected"},
+		},
+		{
+			"should list the messages matching the filter",
+			[]string{"-filter", `attributes.region = "CA"`},
+			[]string{"order from CA", "OriginatedAt: 2023-11-14T22:13:20Z", "Attempts:     5", "1 of 2 messages selected"},
+		},
+		{
+			"should list the messages containing the text",
+			[]string{"-contains", "NY"},
+			[]string{"order from NY", "1 of 2 messages selected"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newDLQPubSub(t, "CA", "NY")
+
+			args := append([]string{"-sub", "orders-dlq-sub", "-wait", "100ms"}, tt.args...)
+
+			var out bytes.Buffer
+			if err := dlqList(context.Background(), ps, args, &out); err != nil {
+				t.Fatalf("dlqList() error = %v", err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("dlqList() output = %s, want it to contain %q", out.String(), w)
+				}
+			}
+		})
+	}
+}
+
+func TestDLQRedrive(t *testing.T) {
+	ps := newDLQPubSub(t, "CA", "NY")
+	ctx := context.Background()
+
+	args := []string{"-sub", "orders-dlq-sub", "-wait", "100ms", "-filter", `attributes.region = "CA"`}
+
+	var out bytes.Buffer
+	if err := dlqRedrive(ctx, ps, args, &out); err != nil {
+		t.Fatalf("dlqRedrive() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "1 of 2 messages redriven") {
+		t.Errorf("dlqRedrive() output = %s, want 1 of 2 messages redriven", out.String())
+	}
+
+	// the redriven message should be back on the original topic
+	rctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var got *psb.Message
+	err := ps.ReceiveFunc(rctx, "orders-sub", func(ctx context.Context, m *psb.Message) error {
+		got = m
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReceiveFunc() error = %v", err)
+	}
+	if got == nil {
+		t.Fatal("the redriven message was not received")
+	}
+
+	if string(got.Data) != "order from CA" {
+		t.Errorf("redriven data = %s, want order from CA", got.Data)
+	}
+	if got.Attributes["region"] != "CA" || got.Attributes["OriginatedAt"] != "1700000000" {
+		t.Errorf("redriven attributes = %v, want the original attributes", got.Attributes)
+	}
+	if _, ok := got.Attributes[redrivenAtAttribute]; !ok {
+		t.Errorf("redriven attributes = %v, want %s", got.Attributes, redrivenAtAttribute)
+	}
+	if _, ok := got.Attributes[deliveryCountAttribute]; ok {
+		t.Errorf("redriven attributes = %v, want no dead letter attributes", got.Attributes)
+	}
+
+	// only the unselected message should remain
+	out.Reset()
+	if err := dlqList(ctx, ps, []string{"-sub", "orders-dlq-sub", "-wait", "100ms"}, &out); err != nil {
+		t.Fatalf("dlqList() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "1 of 1 messages selected") || !strings.Contains(out.String(), "order from NY") {
+		t.Errorf("dlqList() output = %s, want only the NY message", out.String())
+	}
+}
+
+func TestDLQRedrive_Compressed(t *testing.T) {
+	ps := newDLQPubSub(t)
+	ctx := context.Background()
+
+	data, err := psb.GzipCompressor.Compress([]byte("order from CA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ps.Publish("orders-dlq", data, map[string]string{
+		psb.ContentEncodingAttribute: "gzip",
+		sourceSubscriptionAttribute:  "projects/test-project/subscriptions/orders-sub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := dlqRedrive(ctx, ps, []string{"-sub", "orders-dlq-sub", "-wait", "100ms"}, &out); err != nil {
+		t.Fatalf("dlqRedrive() error = %v", err)
+	}
+
+	// the redriven message should be published as it was received
+	rctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var got *psb.Message
+	err = ps.Backend().Subscription("orders-sub").Receive(rctx, pubsub.DefaultReceiveSettings, func(ctx context.Context, m *psb.Message) {
+		got = m
+		m.Ack()
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if got == nil {
+		t.Fatal("the redriven message was not received")
+	}
+
+	if !bytes.Equal(got.Data, data) || got.Attributes[psb.ContentEncodingAttribute] != "gzip" {
+		t.Errorf("redriven %q with attributes %v, want the compressed data", got.Data, got.Attributes)
+	}
+}
+
+func TestDLQRedrive_DryRun(t *testing.T) {
+	ps := newDLQPubSub(t, "CA")
+
+	var out bytes.Buffer
+	if err := dlqRedrive(context.Background(), ps, []string{"-sub", "orders-dlq-sub", "-wait", "100ms", "-dry-run"}, &out); err != nil {
+		t.Fatalf("dlqRedrive() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "to orders") {
+		t.Errorf("dlqRedrive() output = %s, want the message to be redriven to orders", out.String())
+	}
+
+	// the message should remain in the dead letter subscription
+	out.Reset()
+	if err := dlqList(context.Background(), ps, []string{"-sub", "orders-dlq-sub", "-wait", "100ms"}, &out); err != nil {
+		t.Fatalf("dlqList() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "1 of 1 messages selected") {
+		t.Errorf("dlqList() output = %s, want the message to remain", out.String())
+	}
+}
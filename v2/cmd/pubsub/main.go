@@ -0,0 +1,132 @@
+// Command pubsub inspects and manages the topics, subscriptions and messages
+// of Google Cloud Pub/Sub, or the Pub/Sub emulator, using the pubsub-go
+// library.
+//
+// Usage:
+//
+//	pubsub [-project id] [-emulator host:port] <command> [arguments]
+//
+// The commands are:
+//
+//	publish      publish messages from flags, a file or stdin
+//	tail         print and ack the messages of a subscription as they arrive
+//	peek         print the messages waiting in a subscription without acking them
+//	topics       list or create topics
+//	subs         list or create subscriptions
+//	dlq list     list the messages in a dead letter subscription
+//	dlq redrive  publish dead lettered messages back to their original topic
+//
+// The project defaults to $PUBSUB_PROJECT_ID or $GOOGLE_CLOUD_PROJECT, and the
+// emulator defaults to $PUBSUB_EMULATOR_HOST.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// command runs a subcommand with the arguments that follow its name.
+type command func(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error
+
+var commands = map[string]command{
+	"dlq":     runDLQ,
+	"peek":    runPeek,
+	"publish": runPublish,
+	"subs":    runSubs,
+	"tail":    runTail,
+	"topics":  runTopics,
+}
+
+const usage = `Usage: pubsub [-project id] [-emulator host:port] <command> [arguments]
+
+Commands:
+  publish      publish messages from flags, a file or stdin
+  tail         print and ack the messages of a subscription as they arrive
+  peek         print the messages waiting in a subscription without acking them
+  topics       list or create topics
+  subs         list or create subscriptions
+  dlq list     list the messages in a dead letter subscription
+  dlq redrive  publish dead lettered messages back to their original topic
+
+Run "pubsub <command> -h" for the arguments of a command.
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "pubsub: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("pubsub", flag.ContinueOnError)
+	project := fs.String("project", defaultProject(), "the Google Cloud project ID")
+	emulator := fs.String("emulator", "", "the host:port of a Pub/Sub emulator to connect to instead of Google Cloud")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	if *project == "" {
+		return errors.New("a project ID is required, set -project or $PUBSUB_PROJECT_ID")
+	}
+
+	ps, err := psb.NewPubSub(ctx, psb.Options(*project, emulatorOptions(*emulator)...))
+	if err != nil {
+		return err
+	}
+	defer ps.Close()
+
+	return cmd(ctx, ps, fs.Args()[1:], in, out)
+}
+
+// emulatorOptions returns the client options that connect to the emulator,
+// if one is provided. The client connects to $PUBSUB_EMULATOR_HOST by itself.
+func emulatorOptions(host string) []option.ClientOption {
+	if host == "" {
+		return nil
+	}
+
+	return []option.ClientOption{
+		option.WithEndpoint(host),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+func defaultProject() string {
+	if pID := os.Getenv("PUBSUB_PROJECT_ID"); pID != "" {
+		return pID
+	}
+
+	return os.Getenv("GOOGLE_CLOUD_PROJECT")
+}
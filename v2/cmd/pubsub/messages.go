@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub"
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// collector receives the messages that are waiting in a subscription. Raw
+// messages are received as they were published, without being decrypted or
+// decompressed.
+type collector struct {
+	max  int
+	raw  bool
+	sub  string
+	wait time.Duration
+}
+
+func (c *collector) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.sub, "sub", "", "the subscription to receive messages from (required)")
+	fs.IntVar(&c.max, "max", 100, "the maximum number of messages to receive")
+	fs.DurationVar(&c.wait, "wait", 5*time.Second, "how long to wait for another message before stopping")
+}
+
+// collect receives messages until the maximum has been received or no message
+// arrives within the wait. The messages are held without being acked or nacked
+// so that each is only received once, and the caller must settle them.
+func (c *collector) collect(ctx context.Context, ps *psb.PubSub) ([]*psb.Message, error) {
+	if c.sub == "" {
+		return nil, errors.New("-sub is required")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mc := make(chan *psb.Message)
+	errc := make(chan error, 1)
+	go func() {
+		if !c.raw {
+			errc <- ps.ReceiveMessages(ctx, c.sub, mc)
+			return
+		}
+
+		errc <- ps.Backend().Subscription(c.sub).Receive(ctx, pubsub.DefaultReceiveSettings, func(ctx context.Context, m *psb.Message) {
+			select {
+			case mc <- m:
+			case <-ctx.Done():
+				m.Nack()
+			}
+		})
+	}()
+
+	var msgs []*psb.Message
+	seen := make(map[string]bool)
+loop:
+	for len(msgs) < c.max {
+		select {
+		case m := <-mc:
+			// leases that expire while waiting are redelivered
+			if seen[m.ID] {
+				m.Nack()
+				continue
+			}
+
+			seen[m.ID] = true
+			msgs = append(msgs, m)
+		case <-time.After(c.wait):
+			break loop
+		case <-ctx.Done():
+			break loop
+		case err := <-errc:
+			nackAll(msgs)
+			return nil, err
+		}
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		nackAll(msgs)
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+func nackAll(msgs []*psb.Message) {
+	for _, m := range msgs {
+		m.Nack()
+	}
+}
+
+// selector selects received messages by their attributes, data or ID.
+type selector struct {
+	contains string
+	expr     string
+	filter   func(map[string]string) bool
+	ids      string
+}
+
+func (s *selector) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.expr, "filter", "", `only select messages whose attributes match the Pub/Sub filter, e.g. 'attributes.region = "CA"'`)
+	fs.StringVar(&s.contains, "contains", "", "only select messages whose data contains the text")
+	fs.StringVar(&s.ids, "ids", "", "only select the messages with the comma separated IDs")
+}
+
+// compile parses the filter once the flags have been parsed.
+func (s *selector) compile() error {
+	f, err := psb.ParseFilter(s.expr)
+	if err != nil {
+		return fmt.Errorf("invalid -filter: %w", err)
+	}
+
+	s.filter = f
+	return nil
+}
+
+// selects reports whether any messages are left out.
+func (s *selector) selects() bool {
+	return s.expr != "" || s.contains != "" || s.ids != ""
+}
+
+func (s *selector) match(m *psb.Message) bool {
+	if s.ids != "" && !contains(strings.Split(s.ids, ","), m.ID) {
+		return false
+	}
+	if s.contains != "" && !strings.Contains(string(m.Data), s.contains) {
+		return false
+	}
+
+	return s.filter(m.Attributes)
+}
+
+func contains(vals []string, v string) bool {
+	for _, val := range vals {
+		if strings.TrimSpace(val) == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// output prints received messages.
+type output struct {
+	json bool
+}
+
+func (o *output) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.json, "json", false, "print each message as a JSON object on a single line")
+}
+
+func (o *output) print(out io.Writer, m *psb.Message) error {
+	if o.json {
+		return printJSON(out, m)
+	}
+
+	printMessage(out, m)
+	return nil
+}
+
+// printMessage writes the message in a human readable form, indenting data
+// that is JSON.
+func printMessage(out io.Writer, m *psb.Message) {
+	fmt.Fprintf(out, "ID:           %s\n", m.ID)
+	fmt.Fprintf(out, "Published:    %s\n", m.PublishTime.UTC().Format(time.RFC3339))
+	if oa, ok := attributeTime(m.Attributes, "OriginatedAt"); ok {
+		fmt.Fprintf(out, "OriginatedAt: %s\n", oa.Format(time.RFC3339))
+	}
+	if dc, ok := m.Attributes[deliveryCountAttribute]; ok {
+		fmt.Fprintf(out, "Attempts:     %s\n", dc)
+	}
+	if m.OrderingKey != "" {
+		fmt.Fprintf(out, "OrderingKey:  %s\n", m.OrderingKey)
+	}
+
+	keys := make([]string, 0, len(m.Attributes))
+	for k := range m.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(out, "Attributes:")
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %s=%s\n", k, m.Attributes[k])
+	}
+
+	// align indented JSON with the label
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, m.Data, "              ", "  "); err == nil {
+		fmt.Fprintf(out, "Data:         %s\n\n", buf.String())
+		return
+	}
+
+	fmt.Fprintf(out, "Data:         %s\n\n", formatData(m.Data))
+}
+
+// jsonMessage is the JSON form of a message printed with -json.
+type jsonMessage struct {
+	ID          string            `json:"id"`
+	PublishTime time.Time         `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Data        any               `json:"data"`
+}
+
+// printJSON writes the message as a JSON object on a single line. Data that is
+// JSON is embedded as is, and other data is written as a string.
+func printJSON(out io.Writer, m *psb.Message) error {
+	jm := jsonMessage{
+		ID:          m.ID,
+		PublishTime: m.PublishTime.UTC(),
+		OrderingKey: m.OrderingKey,
+		Attributes:  m.Attributes,
+		Data:        formatData(m.Data),
+	}
+	if json.Valid(m.Data) {
+		jm.Data = json.RawMessage(m.Data)
+	}
+
+	return json.NewEncoder(out).Encode(jm)
+}
+
+// attributeTime parses an attribute holding Unix seconds, such as
+// OriginatedAt.
+func attributeTime(attrs map[string]string, k string) (time.Time, bool) {
+	v, ok := attrs[k]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(secs, 0).UTC(), true
+}
+
+// formatData returns the data as text, or base64 when it is not valid UTF-8.
+func formatData(dta []byte) string {
+	if utf8.Valid(dta) {
+		return string(dta)
+	}
+
+	return "base64:" + base64.StdEncoding.EncodeToString(dta)
+}
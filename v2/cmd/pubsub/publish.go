@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// maxMessageSize is the largest message Google Cloud Pub/Sub accepts.
+const maxMessageSize = 10 << 20
+
+// attributes collects repeated -attr key=value flags.
+type attributes map[string]string
+
+func (a attributes) String() string {
+	kvs := make([]string, 0, len(a))
+	for k, v := range a {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+
+	return strings.Join(kvs, ",")
+}
+
+func (a attributes) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("attribute %q must be in the form key=value", v)
+	}
+
+	a[k] = val
+	return nil
+}
+
+// runPublish publishes the data from -data, -file or stdin as a single message,
+// or each of its lines as a separate message with -lines.
+func runPublish(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	topic := fs.String("topic", "", "the topic to publish to (required)")
+	data := fs.String("data", "", "the message data, instead of reading it from -file or stdin")
+	file := fs.String("file", "", `the file to read the message data from, or "-" for stdin (the default)`)
+	lines := fs.Bool("lines", false, "publish each non-empty line of the input as a separate message")
+	attrs := attributes{}
+	fs.Var(attrs, "attr", "a message attribute in the form key=value, which may be repeated")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *topic == "" {
+		return errors.New("-topic is required")
+	}
+
+	r, err := publishInput(*data, *file, in)
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok && r != in {
+		defer c.Close()
+	}
+
+	n := 0
+	if *lines {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), maxMessageSize)
+		for sc.Scan() {
+			if len(sc.Bytes()) == 0 {
+				continue
+			}
+
+			// the scanner reuses its buffer
+			dta := append([]byte(nil), sc.Bytes()...)
+			if err := ps.Publish(*topic, dta, attrs); err != nil {
+				return err
+			}
+			n++
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	} else {
+		dta, err := io.ReadAll(io.LimitReader(r, maxMessageSize+1))
+		if err != nil {
+			return err
+		}
+		if len(dta) > maxMessageSize {
+			return fmt.Errorf("the message data is larger than %d bytes", maxMessageSize)
+		}
+
+		if err := ps.Publish(*topic, dta, attrs); err != nil {
+			return err
+		}
+		n++
+	}
+
+	fmt.Fprintf(out, "published %d messages to %s\n", n, *topic)
+
+	return nil
+}
+
+// publishInput returns the reader of the message data.
+func publishInput(data string, file string, in io.Reader) (io.Reader, error) {
+	switch {
+	case data != "" && file != "":
+		return nil, errors.New("only one of -data or -file may be provided")
+	case data != "":
+		return strings.NewReader(data), nil
+	case file != "" && file != "-":
+		return os.Open(file)
+	default:
+		return in, nil
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// newCLIPubSub returns a memory PubSub with an orders topic and subscription.
+func newCLIPubSub(t *testing.T) *psb.PubSub {
+	t.Helper()
+
+	ps, err := psb.NewPubSub(context.Background(), psb.Options("test-project").SetBackend(psb.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+
+	if err := ps.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("orders", "orders-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return ps
+}
+
+// receiveData acks and returns the data of the n messages in the subscription.
+func receiveData(t *testing.T, ps *psb.PubSub, sid string, n int) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var got []string
+	err := ps.ReceiveFunc(ctx, sid, func(ctx context.Context, m *psb.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		got = append(got, string(m.Data))
+		if len(got) == n {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReceiveFunc() error = %v", err)
+	}
+
+	sort.Strings(got)
+	return got
+}
+
+func TestPublish(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		in   string
+		want []string
+	}{
+		{
+			"should publish the data",
+			[]string{"-data", "hello"},
+			"",
+			[]string{"hello"},
+		},
+		{
+			"should publish stdin as a single message",
+			nil,
+			"hello\nworld\n",
+			[]string{"hello\nworld\n"},
+		},
+		{
+			"should publish each line as a message",
+			[]string{"-lines"},
+			"hello\n\nworld\n",
+			[]string{"hello", "world"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newCLIPubSub(t)
+
+			args := append([]string{"-topic", "orders", "-attr", "region=CA"}, tt.args...)
+
+			var out bytes.Buffer
+			if err := runPublish(context.Background(), ps, args, strings.NewReader(tt.in), &out); err != nil {
+				t.Fatalf("runPublish() error = %v", err)
+			}
+
+			if got := receiveData(t, ps, "orders-sub", len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runPublish() published %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublish_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"should require a topic", []string{"-data", "hello"}},
+		{"should reject an invalid attribute", []string{"-topic", "orders", "-attr", "region"}},
+		{"should reject both data and a file", []string{"-topic", "orders", "-data", "hello", "-file", "data.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newCLIPubSub(t)
+
+			var out bytes.Buffer
+			if err := runPublish(context.Background(), ps, tt.args, strings.NewReader(""), &out); err == nil {
+				t.Error("runPublish() error = nil, want an error")
+			}
+		})
+	}
+}
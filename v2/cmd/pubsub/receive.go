@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// errLimitReached nacks the messages received after tail has printed the
+// maximum number of messages.
+var errLimitReached = errors.New("the maximum number of messages has been printed")
+
+func runPeek(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	return peek(ctx, ps, "peek", args, out)
+}
+
+// peek prints the selected messages waiting in a subscription and nacks them
+// so that they remain in it.
+func peek(ctx context.Context, ps *psb.PubSub, name string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	var c collector
+	c.register(fs)
+
+	var sel selector
+	sel.register(fs)
+
+	var o output
+	o.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := sel.compile(); err != nil {
+		return err
+	}
+
+	msgs, err := c.collect(ctx, ps)
+	if err != nil {
+		return err
+	}
+	defer nackAll(msgs)
+
+	n := 0
+	for _, m := range msgs {
+		if !sel.match(m) {
+			continue
+		}
+
+		if err := o.print(out, m); err != nil {
+			return err
+		}
+		n++
+	}
+
+	if !o.json {
+		fmt.Fprintf(out, "%d of %d messages selected\n", n, len(msgs))
+	}
+
+	return nil
+}
+
+// runTail prints the selected messages of a subscription as they arrive until
+// it is interrupted. Every message received is acked, whether or not it is
+// selected, so selecting the messages of -sub requires -ack.
+func runTail(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	sub := fs.String("sub", "", "the subscription to receive messages from")
+	topic := fs.String("topic", "", "the topic to receive messages from through a temporary subscription, instead of -sub")
+	max := fs.Int("max", 0, "stop after printing this many messages, or 0 to never stop")
+	ack := fs.Bool("ack", false, "allow the messages of -sub that are not selected to be acked")
+
+	var sel selector
+	sel.register(fs)
+
+	var o output
+	o.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := sel.compile(); err != nil {
+		return err
+	}
+	if (*sub == "") == (*topic == "") {
+		return errors.New("one of -sub or -topic is required")
+	}
+	if *sub != "" && sel.selects() && !*ack {
+		return errors.New("tail acks the messages of -sub that are not selected, set -ack to allow it or use -topic")
+	}
+
+	// create a temporary subscription that is removed when tail stops
+	sid := *sub
+	if *topic != "" {
+		sid = fmt.Sprintf("%s-tail-%d", *topic, time.Now().UnixNano())
+		if err := ps.CreateSubscription(*topic, sid, sel.expr); err != nil {
+			return err
+		}
+		defer ps.Backend().Subscription(sid).Delete(context.Background())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	n := 0
+	return ps.ReceiveFunc(ctx, sid, func(ctx context.Context, m *psb.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if *max > 0 && n >= *max {
+			return errLimitReached
+		}
+		if !sel.match(m) {
+			return nil
+		}
+
+		if err := o.print(out, m); err != nil {
+			return err
+		}
+
+		n++
+		if *max > 0 && n >= *max {
+			cancel()
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeek(t *testing.T) {
+	ps := newCLIPubSub(t)
+	for _, r := range []string{"CA", "NY"} {
+		if err := ps.Publish("orders", []byte(`{"region":"`+r+`"}`), map[string]string{"region": r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	args := []string{"-sub", "orders-sub", "-wait", "100ms", "-json", "-filter", `attributes.region = "NY"`}
+	if err := runPeek(context.Background(), ps, args, nil, &out); err != nil {
+		t.Fatalf("runPeek() error = %v", err)
+	}
+	if !strings.Contains(out.String(), `"data":{"region":"NY"}`) || strings.Contains(out.String(), "CA") {
+		t.Errorf("runPeek() output = %s, want only the NY message", out.String())
+	}
+
+	// peeked messages should remain in the subscription
+	if got := receiveData(t, ps, "orders-sub", 2); len(got) != 2 {
+		t.Errorf("messages remaining = %v, want 2", got)
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"should tail the subscription", []string{"-sub", "orders-sub", "-ack"}},
+		{"should tail the topic through a temporary subscription", []string{"-topic", "orders"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newCLIPubSub(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// publish once tail is receiving so that the temporary subscription exists
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				for _, r := range []string{"CA", "NY", "TX"} {
+					ps.Publish("orders", []byte("order from "+r), map[string]string{"region": r})
+				}
+			}()
+
+			var out bytes.Buffer
+			args := append(tt.args, "-max", "1", "-filter", `attributes.region = "NY"`)
+			if err := runTail(ctx, ps, args, nil, &out); err != nil {
+				t.Fatalf("runTail() error = %v", err)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("runTail() did not stop after the maximum number of messages")
+			}
+			if !strings.Contains(out.String(), "order from NY") || strings.Contains(out.String(), "order from CA") {
+				t.Errorf("runTail() output = %s, want only the NY message", out.String())
+			}
+
+			// the temporary subscription should be removed
+			ids, err := ps.Backend().Subscriptions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != 1 {
+				t.Errorf("Subscriptions() = %v, want only orders-sub", ids)
+			}
+		})
+	}
+}
+
+func TestTail_SelectWithoutAck(t *testing.T) {
+	ps := newCLIPubSub(t)
+
+	// the unselected messages would be acked
+	args := []string{"-sub", "orders-sub", "-filter", `attributes.region = "NY"`}
+	if err := runTail(context.Background(), ps, args, nil, &bytes.Buffer{}); err == nil {
+		t.Error("runTail() error = nil, want -ack to be required")
+	}
+}
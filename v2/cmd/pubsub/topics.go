@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	psb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// runTopics lists the topics of the project, or creates the topics provided to
+// the create subcommand, reporting the ones that already exist.
+func runTopics(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 || args[0] == "list" {
+		ids, err := ps.Backend().Topics(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			fmt.Fprintln(out, id)
+		}
+
+		return nil
+	}
+
+	if args[0] != "create" {
+		return fmt.Errorf("unknown topics subcommand %q", args[0])
+	}
+	if len(args) == 1 {
+		return errors.New("topics create requires at least one topic ID")
+	}
+
+	for _, id := range args[1:] {
+		exists, err := ps.Backend().Topic(id).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if err := ps.CreateTopic(id); err != nil {
+			return err
+		}
+
+		if exists {
+			fmt.Fprintf(out, "topic %s exists\n", id)
+			continue
+		}
+		fmt.Fprintf(out, "created topic %s\n", id)
+	}
+
+	return nil
+}
+
+// runSubs lists the subscriptions of the project with their topic and filter,
+// or creates the subscriptions provided to the create subcommand.
+func runSubs(ctx context.Context, ps *psb.PubSub, args []string, in io.Reader, out io.Writer) error {
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "list":
+		return listSubs(ctx, ps, args, out)
+	case "create":
+		return createSubs(ctx, ps, args, out)
+	default:
+		return fmt.Errorf("unknown subs subcommand %q", sub)
+	}
+}
+
+func listSubs(ctx context.Context, ps *psb.PubSub, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("subs list", flag.ContinueOnError)
+	topic := fs.String("topic", "", "only list the subscriptions of the topic")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ids, err := ps.Backend().Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBSCRIPTION\tTOPIC\tFILTER")
+	for _, id := range ids {
+		cfg, err := ps.Backend().Subscription(id).Config(ctx)
+		if err != nil {
+			return err
+		}
+		if *topic != "" && cfg.TopicID != *topic {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", id, cfg.TopicID, cfg.Filter)
+	}
+
+	return tw.Flush()
+}
+
+func createSubs(ctx context.Context, ps *psb.PubSub, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("subs create", flag.ContinueOnError)
+	topic := fs.String("topic", "", "the topic to subscribe to (required)")
+	fltr := fs.String("filter", "", "the filter of the subscriptions")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *topic == "" {
+		return errors.New("-topic is required")
+	}
+	if fs.NArg() == 0 {
+		return errors.New("subs create requires at least one subscription ID")
+	}
+
+	for _, id := range fs.Args() {
+		exists, err := ps.Backend().Subscription(id).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if err := ps.CreateSubscription(*topic, id, *fltr); err != nil {
+			return err
+		}
+
+		if exists {
+			fmt.Fprintf(out, "subscription %s exists\n", id)
+			continue
+		}
+		fmt.Fprintf(out, "created subscription %s to %s\n", id, *topic)
+	}
+
+	return nil
+}
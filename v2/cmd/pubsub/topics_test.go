@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTopics(t *testing.T) {
+	ps := newCLIPubSub(t)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := runTopics(ctx, ps, []string{"create", "invoices", "orders", "payments"}, nil, &out); err != nil {
+		t.Fatalf("runTopics() error = %v", err)
+	}
+	if want := "created topic invoices\ntopic orders exists\ncreated topic payments\n"; out.String() != want {
+		t.Errorf("runTopics() output = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := runTopics(ctx, ps, nil, nil, &out); err != nil {
+		t.Fatalf("runTopics() error = %v", err)
+	}
+	if got := strings.Fields(out.String()); strings.Join(got, ",") != "invoices,orders,payments" {
+		t.Errorf("runTopics() output = %v, want invoices, orders and payments", got)
+	}
+}
+
+func TestSubs(t *testing.T) {
+	ps := newCLIPubSub(t)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := runTopics(ctx, ps, []string{"create", "invoices"}, nil, &out); err != nil {
+		t.Fatalf("runTopics() error = %v", err)
+	}
+	args := []string{"create", "-topic", "invoices", "-filter", `attributes.region = "CA"`, "invoices-ca"}
+	if err := runSubs(ctx, ps, args, nil, &out); err != nil {
+		t.Fatalf("runSubs() error = %v", err)
+	}
+
+	out.Reset()
+	if err := runSubs(ctx, ps, []string{"create", "-topic", "orders", "orders-sub"}, nil, &out); err != nil {
+		t.Fatalf("runSubs() error = %v", err)
+	}
+	if want := "subscription orders-sub exists\n"; out.String() != want {
+		t.Errorf("runSubs() output = %q, want %q", out.String(), want)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		skip string
+	}{
+		{"should list every subscription", nil, []string{"invoices-ca", `attributes.region = "CA"`, "orders-sub"}, ""},
+		{"should list the subscriptions of the topic", []string{"list", "-topic", "invoices"}, []string{"invoices-ca"}, "orders-sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := runSubs(ctx, ps, tt.args, nil, &out); err != nil {
+				t.Fatalf("runSubs() error = %v", err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("runSubs() output = %s, want it to contain %q", out.String(), w)
+				}
+			}
+			if tt.skip != "" && strings.Contains(out.String(), tt.skip) {
+				t.Errorf("runSubs() output = %s, want it not to contain %q", out.String(), tt.skip)
+			}
+		})
+	}
+}
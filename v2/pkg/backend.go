@@ -0,0 +1,139 @@
+package pb
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Backend is the messaging system that PubSub manages topics and
+// subscriptions on, and publishes and receives messages through. NewPubSub
+// connects to Google Cloud Pub/Sub unless another Backend, such as the one
+// returned by NewMemoryBackend, is provided via PubSubOptions.SetBackend.
+type Backend interface {
+	Close() error
+	CreateSubscription(ctx context.Context, id string, tid string, cfg pubsub.SubscriptionConfig) error
+	CreateTopic(ctx context.Context, id string, cfg *pubsub.TopicConfig) error
+	Subscription(id string) Subscription
+	Subscriptions(ctx context.Context) ([]string, error)
+	Topic(id string) Topic
+	Topics(ctx context.Context) ([]string, error)
+}
+
+// Topic is a handle to a topic of a Backend. Obtaining a handle does not
+// check that the topic exists. String returns the fully qualified name of the
+// topic, which is how it is referenced by dead letter policies. Once a message
+// with an ordering key fails to publish, later messages with the key fail
+// until ResumePublish is called.
+type Topic interface {
+	Config(ctx context.Context) (pubsub.TopicConfig, error)
+	Delete(ctx context.Context) error
+	Exists(ctx context.Context) (bool, error)
+	ID() string
+	Publish(ctx context.Context, m *pubsub.Message) PublishResult
+	ResumePublish(key string)
+	SetMessageOrdering(enabled bool)
+	SetPublishSettings(s pubsub.PublishSettings)
+	Stop()
+	String() string
+	Update(ctx context.Context, cfg pubsub.TopicConfigToUpdate) error
+}
+
+// Subscription is a handle to a subscription of a Backend. Obtaining a handle
+// does not check that the subscription exists.
+type Subscription interface {
+	Config(ctx context.Context) (SubscriptionConfig, error)
+	Delete(ctx context.Context) error
+	Exists(ctx context.Context) (bool, error)
+	ID() string
+	Receive(ctx context.Context, s pubsub.ReceiveSettings, f func(context.Context, *Message)) error
+	Update(ctx context.Context, cfg pubsub.SubscriptionConfigToUpdate) error
+}
+
+// SubscriptionConfig is the configuration of an existing subscription. The
+// embedded Topic handle is only set by the Google Cloud Pub/Sub backend, so
+// TopicID should be used to identify the topic the subscription belongs to.
+type SubscriptionConfig struct {
+	pubsub.SubscriptionConfig
+	TopicID string
+}
+
+// PublishResult holds the result of publishing a message. Get blocks until the
+// message has been published and returns the server-generated message ID.
+type PublishResult interface {
+	Get(ctx context.Context) (string, error)
+	Ready() <-chan struct{}
+}
+
+// AckResult holds the result of acking or nacking a message. Get blocks until
+// the ack or nack has been confirmed. For subscriptions without exactly-once
+// delivery, it always succeeds.
+type AckResult interface {
+	Get(ctx context.Context) (pubsub.AcknowledgeStatus, error)
+	Ready() <-chan struct{}
+}
+
+// ackResult is an AckResult that is resolved when it is created.
+type ackResult struct {
+	err    error
+	ready  chan struct{}
+	status pubsub.AcknowledgeStatus
+}
+
+func resolvedAckResult(s pubsub.AcknowledgeStatus, err error) *ackResult {
+	r := &ackResult{
+		err:    err,
+		ready:  make(chan struct{}),
+		status: s,
+	}
+	close(r.ready)
+
+	return r
+}
+
+func (r *ackResult) Get(ctx context.Context) (pubsub.AcknowledgeStatus, error) {
+	return r.status, r.err
+}
+
+func (r *ackResult) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// publishResult is a PublishResult that is resolved by the caller.
+type publishResult struct {
+	err   error
+	id    string
+	ready chan struct{}
+}
+
+func newPublishResult() *publishResult {
+	return &publishResult{
+		ready: make(chan struct{}),
+	}
+}
+
+func resolvedPublishResult(id string, err error) *publishResult {
+	r := newPublishResult()
+	r.resolve(id, err)
+
+	return r
+}
+
+func (r *publishResult) resolve(id string, err error) {
+	r.id = id
+	r.err = err
+	close(r.ready)
+}
+
+func (r *publishResult) Get(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-r.ready:
+		return r.id, r.err
+	}
+}
+
+func (r *publishResult) Ready() <-chan struct{} {
+	return r.ready
+}
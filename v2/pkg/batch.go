@@ -0,0 +1,90 @@
+package pb
+
+import (
+	"context"
+	"fmt"
+)
+
+// Msg is a message published with PublishBatch. Data is marshalled in the same
+// way as by Publish, or with Codec when it is set, and OrderingKey is set as by
+// PublishWithOrderingKey.
+type Msg struct {
+	Attributes  map[string]string
+	Codec       Codec
+	Data        any
+	OrderingKey string
+}
+
+// BatchError is returned by PublishBatch when some of the messages could not
+// be published. Errors has an entry for every message of the batch, which is
+// nil for the messages that were published.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	n := 0
+	var first error
+	for _, err := range e.Errors {
+		if err == nil {
+			continue
+		}
+
+		if first == nil {
+			first = err
+		}
+		n++
+	}
+
+	return fmt.Sprintf("%d of %d messages failed to publish, the first with: %v", n, len(e.Errors), first)
+}
+
+// Unwrap returns the errors of the messages that failed, so that errors.Is and
+// errors.As match any of them.
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// PublishBatch publishes the messages to the topic and waits until every one
+// has been sent or has failed. The messages are handed to the topic together,
+// so they are bundled into as few requests as the PublishSettings allow. It
+// returns the server-generated message ID of each message, in the order of
+// msgs, and a *BatchError identifying the messages that failed, whose IDs are
+// empty.
+func (p *PubSub) PublishBatch(ctx context.Context, id string, msgs []Msg) ([]string, error) {
+	t := p.topic(id)
+
+	res := make([]PublishResult, len(msgs))
+	for i, m := range msgs {
+		res[i] = p.publishAsync(ctx, t, m)
+	}
+
+	ids := make([]string, len(msgs))
+	var errs []error
+	for i, r := range res {
+		mid, err := r.Get(ctx)
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(msgs))
+			}
+
+			errs[i] = err
+			continue
+		}
+
+		ids[i] = mid
+	}
+
+	if errs != nil {
+		return ids, &BatchError{Errors: errs}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,62 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPubSub_PublishBatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		if err := ps.CreateTopic("topic"); err != nil {
+			t.Fatalf("CreateTopic() error = %v", err)
+		}
+		if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+			t.Fatalf("CreateSubscription() error = %v", err)
+		}
+
+		msgs := []Msg{
+			{Data: []byte("first")},
+			{Data: make(chan int)},
+			{Data: []byte("third"), Attributes: map[string]string{"region": "CA"}},
+		}
+		ids, err := ps.PublishBatch(context.Background(), "topic", msgs)
+
+		var be *BatchError
+		if !errors.As(err, &be) {
+			t.Fatalf("PublishBatch() error = %v, want a *BatchError", err)
+		}
+		if be.Errors[0] != nil || be.Errors[1] == nil || be.Errors[2] != nil {
+			t.Errorf("PublishBatch() errors = %v, want only the second message to fail", be.Errors)
+		}
+		if ids[0] == "" || ids[1] != "" || ids[2] == "" || ids[0] == ids[2] {
+			t.Errorf("PublishBatch() ids = %q, want IDs for the first and third messages", ids)
+		}
+
+		got := receiveN(t, ps, "sub", 2, func(ctx context.Context, m *Message) error { return nil })
+		if len(got) != 2 {
+			t.Errorf("received %v, want the first and third messages", got)
+		}
+	})
+}
+
+func TestPubSub_PublishAsync(t *testing.T) {
+	ps := newMemoryPubSub(t)
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatalf("CreateTopic() error = %v", err)
+	}
+
+	ctx := context.Background()
+	first, err := ps.PublishAsync("topic", "hello").Get(ctx)
+	if err != nil || first == "" {
+		t.Fatalf("PublishAsync().Get() = %q, %v, want a message ID", first, err)
+	}
+	second, err := ps.PublishAsync("topic", "world").Get(ctx)
+	if err != nil || second == first {
+		t.Errorf("PublishAsync().Get() = %q, %v, want a new message ID", second, err)
+	}
+
+	if _, err := ps.PublishAsync("topic", make(chan int)).Get(ctx); err == nil {
+		t.Error("PublishAsync().Get() error = nil, want the marshal error")
+	}
+}
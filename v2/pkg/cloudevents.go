@@ -0,0 +1,441 @@
+package pb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CloudEventPrefix prefixes the attributes that hold the context
+	// attributes of CloudEvents published in binary mode.
+	CloudEventPrefix = "ce-"
+
+	// CloudEventContentTypeAttribute is the attribute that holds the content
+	// type of the data of CloudEvents published in binary mode, or
+	// CloudEventsJSON for those published in structured mode.
+	CloudEventContentTypeAttribute = "content-type"
+
+	// CloudEventsJSON is the content type of CloudEvents published in
+	// structured mode.
+	CloudEventsJSON = "application/cloudevents+json"
+
+	// CloudEventSpecVersion is the version of the CloudEvents specification
+	// that is supported.
+	CloudEventSpecVersion = "1.0"
+)
+
+// CloudEventMode is the way a CloudEvent is mapped onto a message, following
+// the Google Cloud Pub/Sub protocol binding.
+type CloudEventMode int
+
+const (
+	// CloudEventBinary publishes the event data as the message data, and the
+	// context attributes as ce- prefixed attributes.
+	CloudEventBinary CloudEventMode = iota
+	// CloudEventStructured publishes the whole event as JSON in the message
+	// data, with the content-type attribute set to CloudEventsJSON.
+	CloudEventStructured
+)
+
+// ErrNotCloudEvent is returned when decoding a message that is neither a
+// binary nor a structured mode CloudEvent.
+var ErrNotCloudEvent = errors.New("message is not a CloudEvent")
+
+// CloudEventError is returned for CloudEvents that do not conform to the
+// specification, listing every problem found.
+type CloudEventError struct {
+	Problems []string
+}
+
+func (e *CloudEventError) Error() string {
+	return fmt.Sprintf("invalid CloudEvent: %s", strings.Join(e.Problems, ", "))
+}
+
+// CloudEvent is an event in the CloudEvents format. ID, Source, SpecVersion and
+// Type are required, and Extensions holds any extension context attributes.
+type CloudEvent struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	Time            time.Time
+	Extensions      map[string]string
+	Data            []byte
+}
+
+// reservedAttributes are the context attributes that cannot be extensions.
+var reservedAttributes = map[string]bool{
+	"data":            true,
+	"data_base64":     true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"id":              true,
+	"source":          true,
+	"specversion":     true,
+	"subject":         true,
+	"time":            true,
+	"type":            true,
+}
+
+var extensionName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// SetData marshals the value with the codec registered for the content type
+// and sets it as the data of the event along with DataContentType. []byte
+// values are set as is.
+func (e *CloudEvent) SetData(ct string, v any) error {
+	e.DataContentType = ct
+	if b, ok := v.([]byte); ok {
+		e.Data = b
+		return nil
+	}
+
+	c, ok := CodecFor(ct)
+	if !ok {
+		return fmt.Errorf("no codec is registered for content type %s", ct)
+	}
+
+	d, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.Data = d
+
+	return nil
+}
+
+// DataAs unmarshals the data of the event into v with the codec registered for
+// its DataContentType, or as JSON when it has none. Decoding into a *[]byte
+// always returns the data as is.
+func (e *CloudEvent) DataAs(v any) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = e.Data
+		return nil
+	}
+
+	c := JSONCodec
+	if !isJSONContentType(e.DataContentType) {
+		rc, ok := CodecFor(e.DataContentType)
+		if !ok {
+			return fmt.Errorf("no codec is registered for content type %s", e.DataContentType)
+		}
+
+		c = rc
+	}
+
+	return c.Unmarshal(e.Data, v)
+}
+
+// Validate checks that the required context attributes are set and that the
+// extensions have valid names.
+func (e *CloudEvent) Validate() error {
+	var ps []string
+	if e.SpecVersion != CloudEventSpecVersion {
+		ps = append(ps, fmt.Sprintf("unsupported specversion %q", e.SpecVersion))
+	}
+	if e.ID == "" {
+		ps = append(ps, "missing id")
+	}
+	if e.Source == "" {
+		ps = append(ps, "missing source")
+	}
+	if e.Type == "" {
+		ps = append(ps, "missing type")
+	}
+
+	for _, k := range sortedKeys(e.Extensions) {
+		if reservedAttributes[k] || !extensionName.MatchString(k) {
+			ps = append(ps, fmt.Sprintf("invalid extension name %q", k))
+		}
+	}
+
+	if len(ps) > 0 {
+		return &CloudEventError{Problems: ps}
+	}
+
+	return nil
+}
+
+// PublishCloudEvent validates the event and publishes it to the topic in the
+// mode, using the context. SpecVersion is set to CloudEventSpecVersion when it
+// is empty.
+func (p *PubSub) PublishCloudEvent(ctx context.Context, id string, e *CloudEvent, mode CloudEventMode) error {
+	if e.SpecVersion == "" {
+		e.SpecVersion = CloudEventSpecVersion
+	}
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	m, err := e.message(mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.publishAsync(ctx, p.topic(id), m).Get(ctx)
+	return err
+}
+
+// message maps the event onto a message in the mode.
+func (e *CloudEvent) message(mode CloudEventMode) (Msg, error) {
+	if mode == CloudEventStructured {
+		d, err := json.Marshal(e)
+		if err != nil {
+			return Msg{}, err
+		}
+
+		return Msg{
+			Attributes: map[string]string{CloudEventContentTypeAttribute: CloudEventsJSON},
+			Data:       d,
+		}, nil
+	}
+
+	attrs := map[string]string{
+		CloudEventPrefix + "id":          e.ID,
+		CloudEventPrefix + "source":      e.Source,
+		CloudEventPrefix + "specversion": e.SpecVersion,
+		CloudEventPrefix + "type":        e.Type,
+	}
+	if e.DataContentType != "" {
+		attrs[CloudEventContentTypeAttribute] = e.DataContentType
+	}
+	if e.DataSchema != "" {
+		attrs[CloudEventPrefix+"dataschema"] = e.DataSchema
+	}
+	if e.Subject != "" {
+		attrs[CloudEventPrefix+"subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		attrs[CloudEventPrefix+"time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	for k, v := range e.Extensions {
+		attrs[CloudEventPrefix+k] = v
+	}
+
+	d := e.Data
+	if d == nil {
+		d = []byte{}
+	}
+
+	return Msg{Attributes: attrs, Data: d}, nil
+}
+
+// CloudEvent decodes the message as a binary or structured mode CloudEvent
+// and validates it. It returns ErrNotCloudEvent for other messages, and a
+// *CloudEventError for events that do not conform to the specification.
+func (m *Message) CloudEvent() (*CloudEvent, error) {
+	ct := m.Attributes[CloudEventContentTypeAttribute]
+
+	var e *CloudEvent
+	switch {
+	case strings.HasPrefix(ct, CloudEventsJSON):
+		e = &CloudEvent{}
+		if err := json.Unmarshal(m.Data, e); err != nil {
+			return nil, err
+		}
+	case m.Attributes[CloudEventPrefix+"specversion"] != "":
+		var err error
+		if e, err = binaryCloudEvent(m); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrNotCloudEvent
+	}
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// binaryCloudEvent reads the event from the ce- prefixed attributes.
+func binaryCloudEvent(m *Message) (*CloudEvent, error) {
+	e := &CloudEvent{
+		DataContentType: m.Attributes[CloudEventContentTypeAttribute],
+		Data:            m.Data,
+	}
+
+	var ps []string
+	for _, k := range sortedKeys(m.Attributes) {
+		name, ok := strings.CutPrefix(k, CloudEventPrefix)
+		if !ok {
+			continue
+		}
+
+		if err := e.setAttribute(name, m.Attributes[k]); err != nil {
+			ps = append(ps, err.Error())
+		}
+	}
+
+	if len(ps) > 0 {
+		return nil, &CloudEventError{Problems: ps}
+	}
+
+	return e, nil
+}
+
+// CloudEventHandler returns a Handler that decodes each message as a
+// CloudEvent and calls the handler with it. Messages that are not valid
+// CloudEvents are nacked without calling the handler.
+func CloudEventHandler(h func(context.Context, *CloudEvent) error) Handler {
+	return func(ctx context.Context, m *Message) error {
+		e, err := m.CloudEvent()
+		if err != nil {
+			return err
+		}
+
+		return h(ctx, e)
+	}
+}
+
+// MarshalJSON encodes the event in the JSON format of structured mode. Data
+// with a JSON content type, or none, is embedded as JSON, and other data is
+// base64 encoded.
+func (e *CloudEvent) MarshalJSON() ([]byte, error) {
+	v := make(map[string]any, 8+len(e.Extensions))
+	for k, x := range e.Extensions {
+		v[k] = x
+	}
+
+	v["id"] = e.ID
+	v["source"] = e.Source
+	v["specversion"] = e.SpecVersion
+	v["type"] = e.Type
+	if e.DataContentType != "" {
+		v["datacontenttype"] = e.DataContentType
+	}
+	if e.DataSchema != "" {
+		v["dataschema"] = e.DataSchema
+	}
+	if e.Subject != "" {
+		v["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		v["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+
+	if e.Data != nil {
+		if isJSONContentType(e.DataContentType) && json.Valid(e.Data) {
+			v["data"] = json.RawMessage(e.Data)
+		} else {
+			v["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an event in the JSON format of structured mode.
+func (e *CloudEvent) UnmarshalJSON(b []byte) error {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*e = CloudEvent{}
+	var ps []string
+	for k, raw := range v {
+		switch k {
+		case "data":
+			e.Data = raw
+		case "data_base64":
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				ps = append(ps, "invalid data_base64")
+				continue
+			}
+			d, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				ps = append(ps, "invalid data_base64")
+				continue
+			}
+			e.Data = d
+		default:
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				ps = append(ps, fmt.Sprintf("attribute %s is not a string", k))
+				continue
+			}
+			if err := e.setAttribute(k, s); err != nil {
+				ps = append(ps, err.Error())
+			}
+		}
+	}
+
+	// data that is a JSON string holds the text of other content types
+	if raw, ok := v["data"]; ok && !isJSONContentType(e.DataContentType) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			e.Data = []byte(s)
+		}
+	}
+
+	if len(ps) > 0 {
+		sort.Strings(ps)
+		return &CloudEventError{Problems: ps}
+	}
+
+	return nil
+}
+
+// setAttribute sets the context attribute of a structured mode event.
+func (e *CloudEvent) setAttribute(k string, v string) error {
+	switch k {
+	case "id":
+		e.ID = v
+	case "source":
+		e.Source = v
+	case "specversion":
+		e.SpecVersion = v
+	case "type":
+		e.Type = v
+	case "datacontenttype":
+		e.DataContentType = v
+	case "dataschema":
+		e.DataSchema = v
+	case "subject":
+		e.Subject = v
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", v)
+		}
+		e.Time = t
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[k] = v
+	}
+
+	return nil
+}
+
+// isJSONContentType reports whether data of the content type is JSON, which
+// is assumed when there is no content type.
+func isJSONContentType(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.TrimSpace(mt)
+
+	return mt == "" || mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,208 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPubSub_PublishCloudEvent(t *testing.T) {
+	type order struct {
+		ID    string `json:"id"`
+		Total int    `json:"total"`
+	}
+
+	tests := []struct {
+		name      string
+		mode      CloudEventMode
+		ct        string
+		data      any
+		wantAttrs map[string]string
+	}{
+		{
+			"should publish in binary mode",
+			CloudEventBinary,
+			"application/json",
+			order{"o-1", 42},
+			map[string]string{
+				"ce-id":          "1",
+				"ce-source":      "/orders",
+				"ce-specversion": "1.0",
+				"ce-type":        "com.example.order.created",
+				"ce-subject":     "o-1",
+				"ce-time":        "2024-05-01T12:00:00Z",
+				"ce-tenant":      "acme",
+				"content-type":   "application/json",
+			},
+		},
+		{
+			"should publish in structured mode",
+			CloudEventStructured,
+			"application/json",
+			order{"o-1", 42},
+			map[string]string{"content-type": CloudEventsJSON},
+		},
+		{
+			"should publish binary data in structured mode",
+			CloudEventStructured,
+			"application/octet-stream",
+			[]byte{0, 1, 2},
+			map[string]string{"content-type": CloudEventsJSON},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			ps.opts.SetAutoOriginatedAt(false)
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			want := &CloudEvent{
+				ID:          "1",
+				Source:      "/orders",
+				Type:        "com.example.order.created",
+				Subject:     "o-1",
+				Time:        time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				Extensions:  map[string]string{"tenant": "acme"},
+				SpecVersion: CloudEventSpecVersion,
+			}
+			if err := want.SetData(tt.ct, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.PublishCloudEvent(context.Background(), "topic", want, tt.mode); err != nil {
+				t.Fatalf("PublishCloudEvent() error = %v", err)
+			}
+
+			var got *CloudEvent
+			var attrs map[string]string
+			receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+				attrs = m.Attributes
+				return CloudEventHandler(func(ctx context.Context, e *CloudEvent) error {
+					got = e
+					return nil
+				})(ctx, m)
+			})
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+			if !reflect.DeepEqual(attrs, tt.wantAttrs) {
+				t.Errorf("attributes = %v, want %v", attrs, tt.wantAttrs)
+			}
+
+			if tt.ct == "application/json" {
+				var o order
+				if err := got.DataAs(&o); err != nil || o != tt.data {
+					t.Errorf("DataAs() = %v, %v, want %v", o, err, tt.data)
+				}
+			}
+		})
+	}
+}
+
+func TestPubSub_PublishCloudEvent_Invalid(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	err := ps.PublishCloudEvent(context.Background(), "topic", &CloudEvent{
+		ID:         "1",
+		Extensions: map[string]string{"Bad-Name": "x"},
+	}, CloudEventBinary)
+
+	var ce *CloudEventError
+	if !errors.As(err, &ce) {
+		t.Fatalf("PublishCloudEvent() error = %v, want a CloudEventError", err)
+	}
+	want := []string{"missing source", "missing type", `invalid extension name "Bad-Name"`}
+	if !reflect.DeepEqual(ce.Problems, want) {
+		t.Errorf("problems = %v, want %v", ce.Problems, want)
+	}
+}
+
+func TestMessage_CloudEvent(t *testing.T) {
+	notEvent := func(err error) bool {
+		return errors.Is(err, ErrNotCloudEvent)
+	}
+	invalid := func(err error) bool {
+		var ce *CloudEventError
+		return errors.As(err, &ce)
+	}
+
+	tests := []struct {
+		name    string
+		msg     *Message
+		want    *CloudEvent
+		wantErr func(error) bool
+	}{
+		{
+			"should decode a binary mode event",
+			&Message{
+				Attributes: map[string]string{
+					"ce-id":          "1",
+					"ce-source":      "/orders",
+					"ce-specversion": "1.0",
+					"ce-type":        "created",
+					"content-type":   "text/plain",
+					"OriginatedAt":   "1700000000",
+				},
+				Data: []byte("hello"),
+			},
+			&CloudEvent{ID: "1", Source: "/orders", SpecVersion: "1.0", Type: "created", DataContentType: "text/plain", Data: []byte("hello")},
+			nil,
+		},
+		{
+			"should decode a structured mode event with text data",
+			&Message{
+				Attributes: map[string]string{"content-type": CloudEventsJSON},
+				Data:       []byte(`{"specversion":"1.0","id":"1","source":"/orders","type":"created","datacontenttype":"text/plain","data":"hello"}`),
+			},
+			&CloudEvent{ID: "1", Source: "/orders", SpecVersion: "1.0", Type: "created", DataContentType: "text/plain", Data: []byte("hello")},
+			nil,
+		},
+		{
+			"should reject messages that are not events",
+			&Message{Attributes: map[string]string{}, Data: []byte("{}")},
+			nil,
+			notEvent,
+		},
+		{
+			"should reject binary events without required attributes",
+			&Message{Attributes: map[string]string{"ce-specversion": "1.0", "ce-id": "1"}},
+			nil,
+			invalid,
+		},
+		{
+			"should reject structured events with an unsupported specversion",
+			&Message{
+				Attributes: map[string]string{"content-type": CloudEventsJSON},
+				Data:       []byte(`{"specversion":"0.3","id":"1","source":"/orders","type":"created"}`),
+			},
+			nil,
+			invalid,
+		},
+		{
+			"should reject events with an invalid time",
+			&Message{Attributes: map[string]string{"ce-specversion": "1.0", "ce-id": "1", "ce-source": "/", "ce-type": "t", "ce-time": "yesterday"}},
+			nil,
+			invalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.msg.CloudEvent()
+
+			if (tt.wantErr == nil && err != nil) || (tt.wantErr != nil && !tt.wantErr(err)) {
+				t.Fatalf("CloudEvent() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
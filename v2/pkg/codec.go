@@ -0,0 +1,189 @@
+package pb
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeAttribute is the message attribute that records the content type
+// of the codec used to marshal the message data.
+const ContentTypeAttribute = "Content-Type"
+
+// Codec marshals values into message data and unmarshals message data back
+// into values. The content type identifies the codec on received messages.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec marshals values as JSON.
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgPackCodec marshals values as MessagePack.
+	MsgPackCodec Codec = msgPackCodec{}
+
+	// ProtobufCodec marshals values that implement proto.Message using the
+	// Protocol Buffers binary wire format.
+	ProtobufCodec Codec = protobufCodec{}
+
+	// RawCodec passes []byte and string values through as is.
+	RawCodec Codec = rawCodec{}
+)
+
+var codecs = struct {
+	mu sync.RWMutex
+	m  map[string]Codec
+}{
+	m: map[string]Codec{
+		JSONCodec.ContentType():     JSONCodec,
+		MsgPackCodec.ContentType():  MsgPackCodec,
+		ProtobufCodec.ContentType(): ProtobufCodec,
+		RawCodec.ContentType():      RawCodec,
+	},
+}
+
+// RegisterCodec registers the codec so that received messages with its content
+// type are decoded with it. Registering a codec for a content type that is
+// already registered replaces it.
+func RegisterCodec(c Codec) {
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+
+	codecs.m[c.ContentType()] = c
+}
+
+// CodecFor returns the registered codec for the content type. Content types
+// with parameters, such as application/json; charset=utf-8, use the codec of
+// their media type unless they are registered themselves.
+func CodecFor(ct string) (Codec, bool) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+
+	if c, ok := codecs.m[ct]; ok {
+		return c, true
+	}
+
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, false
+	}
+
+	c, ok := codecs.m[mt]
+	return c, ok
+}
+
+// encode marshals the data with the codec and records its content type in the
+// attributes unless one is already present. When no codec is provided, []byte
+// values are published as is and all other values use the default codec.
+func encode(c Codec, dflt Codec, d any, attrs map[string]string) ([]byte, error) {
+	if c == nil {
+		if b, ok := d.([]byte); ok {
+			return b, nil
+		}
+
+		c = dflt
+		if c == nil {
+			c = JSONCodec
+		}
+	}
+
+	dta, err := c.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := attrs[ContentTypeAttribute]; !ok {
+		attrs[ContentTypeAttribute] = c.ContentType()
+	}
+
+	return dta, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgPackCodec struct{}
+
+func (msgPackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgPackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgPackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot marshal %T, it does not implement proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec cannot unmarshal into %T, it does not implement proto.Message", v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch d := v.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return nil, fmt.Errorf("raw codec cannot marshal %T, it must be a []byte or string", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch d := v.(type) {
+	case *[]byte:
+		*d = data
+	case *string:
+		*d = string(data)
+	default:
+		return fmt.Errorf("raw codec cannot unmarshal into %T, it must be a *[]byte or *string", v)
+	}
+
+	return nil
+}
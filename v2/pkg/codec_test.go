@@ -0,0 +1,149 @@
+package pb
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		in    any
+		out   func() any
+	}{
+		{
+			"JSONCodec should round trip a struct",
+			JSONCodec,
+			typedExample{13, "hello world"},
+			func() any { return &typedExample{} },
+		},
+		{
+			"MsgPackCodec should round trip a struct",
+			MsgPackCodec,
+			typedExample{13, "hello world"},
+			func() any { return &typedExample{} },
+		},
+		{
+			"ProtobufCodec should round trip a proto message",
+			ProtobufCodec,
+			wrapperspb.String("hello world"),
+			func() any { return &wrapperspb.StringValue{} },
+		},
+		{
+			"RawCodec should round trip a string",
+			RawCodec,
+			"hello world",
+			func() any { return new(string) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dta, err := tt.codec.Marshal(tt.in)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+
+			// decode through a message so that the content type is honoured
+			m := &Message{
+				Data:       dta,
+				Attributes: map[string]string{ContentTypeAttribute: tt.codec.ContentType()},
+			}
+			out := tt.out()
+			if err := m.Decode(out); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			got := reflect.ValueOf(out).Elem().Interface()
+			if pm, ok := tt.in.(proto.Message); ok {
+				if !proto.Equal(pm, out.(proto.Message)) {
+					t.Errorf("Decode() = %v, want %v", out, tt.in)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("Decode() = %v, want %v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestCodecs_Errors(t *testing.T) {
+	if _, err := ProtobufCodec.Marshal(typedExample{}); err == nil {
+		t.Error("ProtobufCodec.Marshal() expected an error for a non proto.Message")
+	}
+	if _, err := RawCodec.Marshal(13); err == nil {
+		t.Error("RawCodec.Marshal() expected an error for an int")
+	}
+	if err := RawCodec.Unmarshal([]byte("13"), new(int)); err == nil {
+		t.Error("RawCodec.Unmarshal() expected an error for an *int")
+	}
+}
+
+func Test_encode(t *testing.T) {
+	tests := []struct {
+		name   string
+		c      Codec
+		dflt   Codec
+		d      any
+		want   string
+		wantCT string
+	}{
+		{"should pass []byte through without a content type", nil, nil, []byte("raw"), "raw", ""},
+		{"should default to JSON", nil, nil, "hello", `"hello"`, "application/json"},
+		{"should use the default codec", nil, RawCodec, "hello", "hello", "application/octet-stream"},
+		{"should prefer the provided codec", JSONCodec, RawCodec, []byte("hi"), `"aGk="`, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string]string{}
+			got, err := encode(tt.c, tt.dflt, tt.d, attrs)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encode() = %s, want %s", got, tt.want)
+			}
+			if attrs[ContentTypeAttribute] != tt.wantCT {
+				t.Errorf("encode() content type = %q, want %q", attrs[ContentTypeAttribute], tt.wantCT)
+			}
+		})
+	}
+}
+
+func TestMessage_Decode(t *testing.T) {
+	t.Run("should use the message codec without a content type", func(t *testing.T) {
+		var got string
+		m := &Message{Data: []byte("hello"), codec: RawCodec}
+		if err := m.Decode(&got); err != nil || got != "hello" {
+			t.Errorf("Decode() = %q, %v, want hello", got, err)
+		}
+	})
+
+	t.Run("should pass raw bytes through", func(t *testing.T) {
+		var got []byte
+		m := &Message{Data: []byte(`"hello"`), Attributes: map[string]string{ContentTypeAttribute: "application/json"}}
+		if err := m.Decode(&got); err != nil || string(got) != `"hello"` {
+			t.Errorf("Decode() = %q, %v, want \"hello\"", got, err)
+		}
+	})
+
+	t.Run("should ignore the parameters of the content type", func(t *testing.T) {
+		var got string
+		m := &Message{Data: []byte(`"hello"`), Attributes: map[string]string{ContentTypeAttribute: "Application/JSON; charset=utf-8"}}
+		if err := m.Decode(&got); err != nil || got != "hello" {
+			t.Errorf("Decode() = %q, %v, want hello", got, err)
+		}
+	})
+
+	t.Run("should return an error for an unknown content type", func(t *testing.T) {
+		var got string
+		m := &Message{Data: []byte("hello"), Attributes: map[string]string{ContentTypeAttribute: "text/unknown"}}
+		if err := m.Decode(&got); err == nil {
+			t.Error("Decode() expected an error")
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package pb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentEncodingAttribute is the message attribute that records the
+// compression applied to the message data.
+const ContentEncodingAttribute = "Content-Encoding"
+
+// maxDecompressedSize limits the size of decompressed message data, so that a
+// small message cannot expand without bound.
+const maxDecompressedSize = 128 << 20
+
+// Compressor compresses message data and decompresses it again. The encoding
+// identifies the compressor on received messages.
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	// GzipCompressor compresses data with gzip.
+	GzipCompressor Compressor = gzipCompressor{}
+
+	// ZstdCompressor compresses data with Zstandard.
+	ZstdCompressor Compressor = &zstdCompressor{}
+)
+
+var compressors = struct {
+	mu sync.RWMutex
+	m  map[string]Compressor
+}{
+	m: map[string]Compressor{
+		GzipCompressor.Encoding(): GzipCompressor,
+		ZstdCompressor.Encoding(): ZstdCompressor,
+	},
+}
+
+// RegisterCompressor registers the compressor so that received messages with
+// its encoding are decompressed with it. Registering a compressor for an
+// encoding that is already registered replaces it.
+func RegisterCompressor(c Compressor) {
+	compressors.mu.Lock()
+	defer compressors.mu.Unlock()
+
+	compressors.m[c.Encoding()] = c
+}
+
+// CompressorFor returns the registered compressor for the encoding.
+func CompressorFor(enc string) (Compressor, bool) {
+	compressors.mu.RLock()
+	defer compressors.mu.RUnlock()
+
+	c, ok := compressors.m[enc]
+	return c, ok
+}
+
+// Compress returns middleware that compresses the data of messages of at least
+// min bytes with the compressor, and records its encoding in the
+// Content-Encoding attribute. Messages that already have the attribute, or
+// whose data does not get smaller, are published as is. Received messages are
+// decompressed before they are handled.
+func Compress(c Compressor, min int) PublishMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			if _, ok := m.Attributes[ContentEncodingAttribute]; ok || len(m.Data) < min {
+				return next(ctx, id, m)
+			}
+
+			d, err := c.Compress(m.Data)
+			if err != nil {
+				return FailedPublishResult(fmt.Errorf("compressing message with %s: %w", c.Encoding(), err))
+			}
+
+			if len(d) < len(m.Data) {
+				m.Data = d
+				m.Attributes[ContentEncodingAttribute] = c.Encoding()
+			}
+
+			return next(ctx, id, m)
+		}
+	}
+}
+
+// decompress replaces the data of a message that has the Content-Encoding
+// attribute with the decompressed data, and removes the attribute.
+func decompress(m *Message) error {
+	enc, ok := m.Attributes[ContentEncodingAttribute]
+	if !ok {
+		return nil
+	}
+
+	c, ok := CompressorFor(enc)
+	if !ok {
+		return fmt.Errorf("no compressor is registered for content encoding %s", enc)
+	}
+
+	d, err := c.Decompress(m.Data)
+	if err != nil {
+		return fmt.Errorf("decompressing message with %s: %w", enc, err)
+	}
+
+	m.Data = d
+	delete(m.Attributes, ContentEncodingAttribute)
+
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	d, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed data is larger than %d bytes", maxDecompressedSize)
+	}
+
+	return d, nil
+}
+
+// zstdCompressor shares an encoder and a decoder, which are safe for
+// concurrent use, between messages.
+type zstdCompressor struct {
+	dec  *zstd.Decoder
+	enc  *zstd.Encoder
+	err  error
+	once sync.Once
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.enc, z.err = zstd.NewWriter(nil); z.err != nil {
+			return
+		}
+		z.dec, z.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+
+	return z.err
+}
+
+func (*zstdCompressor) Encoding() string {
+	return "zstd"
+}
+
+func (z *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+
+	return z.enc.EncodeAll(data, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+
+	return z.dec.DecodeAll(data, nil)
+}
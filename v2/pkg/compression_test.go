@@ -0,0 +1,122 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub_Compress(t *testing.T) {
+	large := []byte(strings.Repeat(`{"screen":"times-square","slot":1}`, 100))
+
+	tests := []struct {
+		name    string
+		c       Compressor
+		data    []byte
+		wantEnc string
+	}{
+		{
+			"should compress with gzip",
+			GzipCompressor,
+			large,
+			"gzip",
+		},
+		{
+			"should compress with zstd",
+			ZstdCompressor,
+			large,
+			"zstd",
+		},
+		{
+			"should not compress messages below the threshold",
+			GzipCompressor,
+			[]byte("small"),
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// capture the messages as they are sent
+			var sent *pubsub.Message
+			capture := func(next PublishFunc) PublishFunc {
+				return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+					sent = m
+					return next(ctx, id, m)
+				}
+			}
+
+			ps := newMemoryPubSub(t)
+			ps.opts.SetPublishMiddleware(Compress(tt.c, 100), capture)
+
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.Publish("topic", tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			if enc := sent.Attributes[ContentEncodingAttribute]; enc != tt.wantEnc {
+				t.Errorf("Content-Encoding = %q, want %q", enc, tt.wantEnc)
+			}
+			if tt.wantEnc != "" && len(sent.Data) >= len(tt.data) {
+				t.Errorf("sent %d bytes, want fewer than %d", len(sent.Data), len(tt.data))
+			}
+
+			receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+				if !bytes.Equal(m.Data, tt.data) {
+					t.Errorf("received %d bytes, want the original %d bytes", len(m.Data), len(tt.data))
+				}
+				if _, ok := m.Attributes[ContentEncodingAttribute]; ok {
+					t.Errorf("attributes = %v, want Content-Encoding to be removed", m.Attributes)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		enc     string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			"should reject unknown encodings",
+			"br",
+			[]byte("hello"),
+			true,
+		},
+		{
+			"should reject corrupt data",
+			"gzip",
+			[]byte("hello"),
+			true,
+		},
+		{
+			"should leave messages without an encoding",
+			"",
+			[]byte("hello"),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{Data: tt.data, Attributes: map[string]string{}}
+			if tt.enc != "" {
+				m.Attributes[ContentEncodingAttribute] = tt.enc
+			}
+
+			if err := decompress(m); (err != nil) != tt.wantErr {
+				t.Errorf("decompress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
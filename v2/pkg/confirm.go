@@ -0,0 +1,72 @@
+package pb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// AckError is reported by ReceiveConfirmed when the ack or nack of a message
+// was not confirmed, such as when its ack ID has expired. A message whose ack
+// failed will be redelivered.
+type AckError struct {
+	Ack       bool
+	Err       error
+	MessageID string
+	Status    pubsub.AcknowledgeStatus
+}
+
+func (e *AckError) Error() string {
+	op := "nack"
+	if e.Ack {
+		op = "ack"
+	}
+
+	return fmt.Sprintf("%s of message %s was not confirmed (status %d): %v", op, e.MessageID, e.Status, e.Err)
+}
+
+func (e *AckError) Unwrap() error {
+	return e.Err
+}
+
+// ReceiveConfirmed subscribes to a topic via the subscription id and calls the
+// handler for each message in the same way as ReceiveFunc, but waits for the
+// ack or nack to be confirmed before taking the message off the outstanding
+// messages. Messages whose ack or nack fails are passed to the error handler
+// with an *AckError, which may be nil to ignore them. It is intended for
+// subscriptions created with WithExactlyOnceDelivery.
+func (p *PubSub) ReceiveConfirmed(ctx context.Context, id string, h Handler, eh func(*Message, error)) error {
+	h = p.receiveChain(id, h)
+	return p.receive(ctx, id, func(ctx context.Context, msg *Message) {
+		ack := handle(ctx, h, msg) == nil
+
+		var r AckResult
+		if ack {
+			r = msg.AckWithResult()
+		} else {
+			r = msg.NackWithResult()
+		}
+
+		// keep waiting for the confirmation when receiving stops, since the
+		// pending acks are still sent
+		s, err := r.Get(context.WithoutCancel(ctx))
+		if err == nil && s == pubsub.AcknowledgeStatusSuccess {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("unsuccessful status %d", s)
+		}
+
+		p.log(ctx, slog.LevelWarn, "ack was not confirmed", slog.String("subscription", id), slog.String("message_id", msg.ID), slog.Any("error", err))
+		if eh != nil {
+			eh(msg, &AckError{
+				Ack:       ack,
+				Err:       err,
+				MessageID: msg.ID,
+				Status:    s,
+			})
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub_ReceiveConfirmed(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		if err := ps.CreateTopic("topic"); err != nil {
+			t.Fatalf("CreateTopic() error = %v", err)
+		}
+		if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithExactlyOnceDelivery()); err != nil {
+			t.Fatalf("CreateSubscriptionWithOptions() error = %v", err)
+		}
+		if err := ps.Publish("topic", []byte("hello world")); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var errs []error
+		err := ps.ReceiveConfirmed(ctx, "sub", func(ctx context.Context, m *Message) error {
+			cancel()
+			return nil
+		}, func(m *Message, err error) {
+			errs = append(errs, err)
+		})
+		if err != nil {
+			t.Fatalf("ReceiveConfirmed() error = %v", err)
+		}
+		if len(errs) != 0 {
+			t.Errorf("ReceiveConfirmed() reported %v, want the ack to be confirmed", errs)
+		}
+
+		cfg, err := ps.Backend().Subscription("sub").Config(context.Background())
+		if err != nil {
+			t.Fatalf("Config() error = %v", err)
+		}
+		if !cfg.EnableExactlyOnceDelivery {
+			t.Error("Config().EnableExactlyOnceDelivery = false, want true")
+		}
+	})
+}
+
+func TestMemoryBackend_ReceiveConfirmed_Expired(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	// let the lease expire rather than extending it
+	ps.opts.ReceiveSettings = pubsub.DefaultReceiveSettings
+	ps.opts.ReceiveSettings.MaxExtension = -1
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatalf("CreateTopic() error = %v", err)
+	}
+	cfg := pubsub.SubscriptionConfig{AckDeadline: 50 * time.Millisecond}
+	if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithSubscriptionConfig(cfg), WithExactlyOnceDelivery()); err != nil {
+		t.Fatalf("CreateSubscriptionWithOptions() error = %v", err)
+	}
+	if err := ps.Publish("topic", []byte("hello world")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// outlive the ack deadline on the first delivery
+	var mu sync.Mutex
+	var errs []error
+	calls := 0
+	err := ps.ReceiveConfirmed(ctx, "sub", func(ctx context.Context, m *Message) error {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+
+		if n == 1 {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}
+
+		cancel()
+		return nil
+	}, func(m *Message, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		errs = append(errs, err)
+	})
+	if err != nil {
+		t.Fatalf("ReceiveConfirmed() error = %v", err)
+	}
+
+	var ae *AckError
+	if len(errs) != 1 || !errors.As(errs[0], &ae) {
+		t.Fatalf("ReceiveConfirmed() reported %v, want one *AckError", errs)
+	}
+	if !ae.Ack || ae.Status != pubsub.AcknowledgeStatusInvalidAckID {
+		t.Errorf("AckError = %+v, want an ack with an invalid ack ID", ae)
+	}
+	if calls != 2 {
+		t.Errorf("message delivered %d times, want it redelivered once", calls)
+	}
+}
@@ -0,0 +1,305 @@
+package pb
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DedupStore records the keys of the messages that have been processed, so
+// that Idempotent can skip messages that are delivered again.
+type DedupStore interface {
+	// Seen reports whether the key has been recorded and has not expired.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Record records the key as processed.
+	Record(ctx context.Context, key string) error
+}
+
+// DedupKey derives the key that a message is deduplicated by. Messages with an
+// empty key are not deduplicated.
+type DedupKey func(*Message) string
+
+// MessageIDKey deduplicates messages by their ID, which catches redeliveries of
+// the same message.
+func MessageIDKey(m *Message) string {
+	return m.ID
+}
+
+// AttributeKey deduplicates messages by the value of the attribute, which
+// catches the same event published more than once when the publisher sets a
+// unique ID in the attribute.
+func AttributeKey(name string) DedupKey {
+	return func(m *Message) string {
+		return m.Attributes[name]
+	}
+}
+
+// ContentHashKey deduplicates messages by the SHA-256 hash of their data.
+func ContentHashKey(m *Message) string {
+	sum := sha256.Sum256(m.Data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Idempotent returns middleware that acks messages whose key has already been
+// recorded in the store without calling the handler. The key is recorded once
+// the handler returns nil, so messages that fail are processed again when they
+// are redelivered. MessageIDKey is used when key is nil.
+//
+// Duplicates that are delivered while the first message is still being handled
+// are not detected. When the key cannot be checked, the error is returned so
+// that the message is nacked. When it cannot be recorded once the handler has
+// succeeded, the error is logged with l, unless it is nil, and the message is
+// still acked, since nacking it would have it handled again. The key is not
+// logged, as it may be derived from the message data.
+func Idempotent(s DedupStore, key DedupKey, l *slog.Logger) ReceiveMiddleware {
+	if key == nil {
+		key = MessageIDKey
+	}
+
+	return func(h Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			k := key(m)
+			if k == "" {
+				return h(ctx, m)
+			}
+
+			seen, err := s.Seen(ctx, k)
+			if err != nil {
+				return fmt.Errorf("checking dedup key %s: %w", k, err)
+			}
+			if seen {
+				return nil
+			}
+
+			if err := h(ctx, m); err != nil {
+				return err
+			}
+
+			if err := s.Record(ctx, k); err != nil && l != nil {
+				l.LogAttrs(ctx, slog.LevelWarn, "failed to record dedup key, the message may be handled again",
+					slog.String("subscription", m.Subscription),
+					slog.String("message_id", m.ID),
+					slog.Any("error", err),
+				)
+			}
+
+			return nil
+		}
+	}
+}
+
+// MemoryDedupStore is a DedupStore that keeps up to a maximum number of keys
+// in memory for the TTL, evicting the least recently recorded keys first. It
+// only deduplicates messages received by the same process.
+type MemoryDedupStore struct {
+	entries map[string]*list.Element
+	lru     *list.List
+	max     int
+	mu      sync.Mutex
+	now     func() time.Time
+	ttl     time.Duration
+}
+
+type dedupEntry struct {
+	expires time.Time
+	key     string
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore that holds up to max keys for
+// the TTL.
+func NewMemoryDedupStore(max int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		max:     max,
+		now:     time.Now,
+		ttl:     ttl,
+	}
+}
+
+func (s *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+
+	// forget expired keys
+	if !s.now().Before(e.Value.(*dedupEntry).expires) {
+		s.lru.Remove(e)
+		delete(s.entries, key)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Record(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(s.ttl)
+	if e, ok := s.entries[key]; ok {
+		e.Value.(*dedupEntry).expires = expires
+		s.lru.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[key] = s.lru.PushFront(&dedupEntry{expires: expires, key: key})
+
+	// evict the least recently recorded keys
+	for s.lru.Len() > s.max {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.entries, e.Value.(*dedupEntry).key)
+	}
+
+	return nil
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLDedupStore is a DedupStore that keeps keys in a database table, so that
+// messages are deduplicated across every process that shares the database.
+// Expired keys are deleted by Sweep, which Record calls at most once per TTL.
+type SQLDedupStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	mu      sync.Mutex
+	now     func() time.Time
+	swept   time.Time
+	table   string
+	ttl     time.Duration
+}
+
+// NewSQLDedupStore returns a SQLDedupStore that keeps keys for the TTL in the
+// table, which is created in the SQL dialect of the database if it does not
+// exist.
+func NewSQLDedupStore(ctx context.Context, db *sql.DB, table string, ttl time.Duration, d SQLDialect) (*SQLDedupStore, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	if d.Placeholder == nil {
+		return nil, errors.New("SQL dialect is not set")
+	}
+
+	if err := createDedupTable(ctx, db, table, d); err != nil {
+		return nil, err
+	}
+
+	return &SQLDedupStore{
+		db:      db,
+		dialect: d,
+		now:     time.Now,
+		swept:   time.Now(),
+		table:   table,
+		ttl:     ttl,
+	}, nil
+}
+
+// createDedupTable creates the table with an index on expires_at for Sweep.
+func createDedupTable(ctx context.Context, db *sql.DB, table string, d SQLDialect) error {
+	idx := fmt.Sprintf("%s_expires_at", table)
+
+	var inline string
+	if d.InlineIndex {
+		inline = fmt.Sprintf(", INDEX %s (expires_at)", idx)
+	}
+
+	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (dedup_key VARCHAR(255) PRIMARY KEY, expires_at BIGINT NOT NULL%s)", table, inline)
+	if _, err := db.ExecContext(ctx, q); err != nil {
+		return err
+	}
+	if d.InlineIndex {
+		return nil
+	}
+
+	q = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)", idx, table)
+	_, err := db.ExecContext(ctx, q)
+
+	return err
+}
+
+func (s *SQLDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE dedup_key = %s AND expires_at > %s", s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	var n int
+	if err := s.db.QueryRowContext(ctx, q, key, s.now().UnixNano()).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Record inserts the key, or extends its expiry when it has already been
+// recorded. The upsert is an update followed by an insert, as the syntax for
+// it differs between databases, and the update is tried again when another
+// process inserts the key in between.
+func (s *SQLDedupStore) Record(ctx context.Context, key string) error {
+	now := s.now()
+	expires := now.Add(s.ttl).UnixNano()
+
+	extended, err := s.extend(ctx, key, expires)
+	if err != nil {
+		return err
+	}
+	if !extended {
+		q := fmt.Sprintf("INSERT INTO %s (dedup_key, expires_at) VALUES (%s, %s)", s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+		if _, err := s.db.ExecContext(ctx, q, key, expires); err != nil {
+			// another process may have inserted the key since
+			if extended, _ := s.extend(ctx, key, expires); !extended {
+				return err
+			}
+		}
+	}
+
+	// a failed sweep is tried again on the next key
+	s.mu.Lock()
+	sweep := now.Sub(s.swept) >= s.ttl
+	s.mu.Unlock()
+	if sweep {
+		s.Sweep(ctx)
+	}
+
+	return nil
+}
+
+// extend sets the expiry of the key, reporting whether it has been recorded.
+func (s *SQLDedupStore) extend(ctx context.Context, key string, expires int64) (bool, error) {
+	q := fmt.Sprintf("UPDATE %s SET expires_at = %s WHERE dedup_key = %s", s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+	res, err := s.db.ExecContext(ctx, q, expires, key)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Sweep deletes the keys that have expired, returning the number of keys
+// deleted.
+func (s *SQLDedupStore) Sweep(ctx context.Context) (int64, error) {
+	now := s.now()
+
+	q := fmt.Sprintf("DELETE FROM %s WHERE expires_at <= %s", s.table, s.dialect.Placeholder(1))
+	res, err := s.db.ExecContext(ctx, q, now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.swept = now
+	s.mu.Unlock()
+
+	return res.RowsAffected()
+}
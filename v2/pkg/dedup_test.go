@@ -0,0 +1,228 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   DedupKey
+		attrs []map[string]string
+		want  int32
+	}{
+		{
+			"should handle messages with different IDs",
+			nil,
+			[]map[string]string{{}, {}},
+			2,
+		},
+		{
+			"should skip messages with the same content",
+			ContentHashKey,
+			[]map[string]string{{}, {}},
+			1,
+		},
+		{
+			"should skip messages with the same attribute",
+			AttributeKey("event-id"),
+			[]map[string]string{{"event-id": "1"}, {"event-id": "1"}, {"event-id": "2"}},
+			2,
+		},
+		{
+			"should handle messages without the attribute",
+			AttributeKey("event-id"),
+			[]map[string]string{{}, {}},
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+				t.Fatal(err)
+			}
+			for _, attrs := range tt.attrs {
+				if err := ps.Publish("topic", []byte("hello world"), attrs); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var calls int32
+			h := Idempotent(NewMemoryDedupStore(10, time.Minute), tt.key, nil)(func(ctx context.Context, m *Message) error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+
+			// every message should be acked, whether or not it is handled
+			receiveN(t, ps, "sub", len(tt.attrs), h)
+
+			if got := atomic.LoadInt32(&calls); got != tt.want {
+				t.Errorf("handler called %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdempotent_HandlerError(t *testing.T) {
+	s := NewMemoryDedupStore(10, time.Minute)
+	m := &Message{ID: "1"}
+
+	var calls int
+	h := Idempotent(s, nil, nil)(func(ctx context.Context, m *Message) error {
+		calls++
+		if calls == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	// the key is only recorded once the handler succeeds
+	for i := 0; i < 3; i++ {
+		h(context.Background(), m)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+// failingDedupStore is a DedupStore that cannot record keys.
+type failingDedupStore struct{}
+
+func (failingDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	return false, nil
+}
+
+func (failingDedupStore) Record(ctx context.Context, key string) error {
+	return errors.New("database is unavailable")
+}
+
+func TestIdempotent_RecordError(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+
+	h := Idempotent(failingDedupStore{}, ContentHashKey, l)(func(ctx context.Context, m *Message) error {
+		return nil
+	})
+
+	// the message was handled, so it should be acked
+	m := &Message{ID: "1", Data: []byte("jane.doe@example.com")}
+	if err := h(context.Background(), m); err != nil {
+		t.Errorf("handler error = %v, want nil", err)
+	}
+
+	if !strings.Contains(buf.String(), "database is unavailable") {
+		t.Errorf("logged %q, want the record error", buf.String())
+	}
+	if strings.Contains(buf.String(), ContentHashKey(m)) {
+		t.Errorf("logged %q, want the dedup key to be left out", buf.String())
+	}
+}
+
+// testDedupStore checks the expiry of a store whose clock is advanced by
+// setting now.
+func testDedupStore(t *testing.T, s DedupStore, now *time.Time) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := s.Record(ctx, "a"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if seen, err := s.Seen(ctx, "a"); err != nil || !seen {
+		t.Errorf("Seen(a) = %v, %v, want true", seen, err)
+	}
+	if seen, err := s.Seen(ctx, "b"); err != nil || seen {
+		t.Errorf("Seen(b) = %v, %v, want false", seen, err)
+	}
+
+	// recording a key again extends it
+	*now = now.Add(40 * time.Second)
+	if err := s.Record(ctx, "a"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	*now = now.Add(40 * time.Second)
+	if seen, _ := s.Seen(ctx, "a"); !seen {
+		t.Error("Seen(a) = false, want the recorded key to be extended")
+	}
+
+	*now = now.Add(time.Minute)
+	if seen, _ := s.Seen(ctx, "a"); seen {
+		t.Error("Seen(a) = true, want the key to have expired")
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryDedupStore(2, time.Minute)
+	s.now = func() time.Time { return now }
+
+	testDedupStore(t, s, &now)
+
+	// the least recently recorded key is evicted
+	ctx := context.Background()
+	for _, k := range []string{"a", "b", "c"} {
+		s.Record(ctx, k)
+	}
+	if seen, _ := s.Seen(ctx, "a"); seen {
+		t.Error("Seen(a) = true, want the key to have been evicted")
+	}
+	if seen, _ := s.Seen(ctx, "c"); !seen {
+		t.Error("Seen(c) = false, want true")
+	}
+}
+
+func TestSQLDedupStore(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "dedup.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := NewSQLDedupStore(context.Background(), db, "dedup; DROP TABLE x", time.Minute, SQLiteDialect); err == nil {
+		t.Error("NewSQLDedupStore() error = nil, want an invalid table name error")
+	}
+
+	s, err := NewSQLDedupStore(context.Background(), db, "processed_messages", time.Minute, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("NewSQLDedupStore() error = %v", err)
+	}
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	testDedupStore(t, s, &now)
+
+	// recording a key a TTL after the last sweep deletes the expired keys
+	ctx := context.Background()
+	now = now.Add(2 * time.Minute)
+	if err := s.Record(ctx, "b"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM processed_messages").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d keys are left, want the expired keys to have been deleted", n)
+	}
+
+	var idx string
+	if err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'processed_messages' AND sql LIKE '%expires_at%'").Scan(&idx); err != nil {
+		t.Errorf("expires_at index was not created: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package pb
+
+import "fmt"
+
+// SQLDialect holds the SQL that differs between the databases that
+// SQLDedupStore and the outbox package keep their tables in.
+type SQLDialect struct {
+	// AutoIncrement is the column definition of an auto-incrementing primary
+	// key.
+	AutoIncrement string
+	// Blob is the column type of binary data.
+	Blob string
+	// InlineIndex is whether indexes are declared in CREATE TABLE, for
+	// databases without CREATE INDEX IF NOT EXISTS.
+	InlineIndex bool
+	// Placeholder returns the bind parameter for the nth (from 1) argument of
+	// a statement.
+	Placeholder func(n int) string
+}
+
+var (
+	// MySQLDialect is the SQLDialect of MySQL and MariaDB.
+	MySQLDialect = SQLDialect{
+		AutoIncrement: "BIGINT AUTO_INCREMENT PRIMARY KEY",
+		Blob:          "LONGBLOB",
+		InlineIndex:   true,
+		Placeholder:   questionPlaceholder,
+	}
+	// PostgresDialect is the SQLDialect of PostgreSQL.
+	PostgresDialect = SQLDialect{
+		AutoIncrement: "BIGSERIAL PRIMARY KEY",
+		Blob:          "BYTEA",
+		Placeholder:   dollarPlaceholder,
+	}
+	// SQLiteDialect is the SQLDialect of SQLite.
+	SQLiteDialect = SQLDialect{
+		AutoIncrement: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Blob:          "BLOB",
+		Placeholder:   questionPlaceholder,
+	}
+)
+
+func questionPlaceholder(n int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
@@ -0,0 +1,315 @@
+package pb
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// the defaults Google Cloud Pub/Sub uses for dead letter and retry policies
+const (
+	defaultMaxDeliveryAttempts = 5
+	defaultMinimumBackoff      = 10 * time.Second
+	defaultMaximumBackoff      = 600 * time.Second
+)
+
+// DriftMode controls what CreateSubscription does when the subscription
+// already exists with a configuration that differs from the one requested.
+type DriftMode int
+
+const (
+	// DriftIgnore leaves the existing subscription as is, without comparing
+	// its configuration. This is the default.
+	DriftIgnore DriftMode = iota
+
+	// DriftFail returns a DriftError without changing the subscription.
+	DriftFail
+
+	// DriftRecreate updates the ack deadline, dead letter policy and retry
+	// policy of the subscription in place, and deletes the subscription and
+	// creates it again with the requested configuration when its filter or
+	// topic differ, losing the messages that have not been acked.
+	DriftRecreate
+)
+
+// driftFields are the fields CreateSubscription compares with the requested
+// configuration of an existing subscription.
+var driftFields = map[string]bool{
+	"ackDeadline": true,
+	"deadLetter":  true,
+	"filter":      true,
+	"retry":       true,
+	"topic":       true,
+}
+
+// immutableFields are the fields that cannot be updated once a subscription
+// has been created.
+var immutableFields = map[string]bool{
+	"filter": true,
+	"topic":  true,
+}
+
+// Change is a configuration field whose current value differs from the value
+// that was requested.
+type Change struct {
+	Field   string
+	Current string
+	Desired string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Field, c.Current, c.Desired)
+}
+
+// DriftError is returned by CreateSubscription when the subscription already
+// exists with a filter, ack deadline, dead letter policy, retry policy or
+// topic that differs from the one requested.
+type DriftError struct {
+	Changes      []Change
+	Subscription string
+}
+
+func (e *DriftError) Error() string {
+	cs := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		cs[i] = c.String()
+	}
+
+	return fmt.Sprintf("subscription %s exists with a different configuration: %s", e.Subscription, strings.Join(cs, ", "))
+}
+
+// checkDrift compares the existing subscription with the requested
+// configuration and handles any drift according to the DriftMode.
+func (p *PubSub) checkDrift(id string, sid string, cfg pubsub.SubscriptionConfig) error {
+	if p.opts.DriftMode == DriftIgnore {
+		return nil
+	}
+
+	s := p.clnt.Subscription(sid)
+	cur, err := s.Config(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	var chs []Change
+	for _, c := range compareSubscription(cur, id, withSubscriptionDefaults(cfg)) {
+		if driftFields[c.Field] {
+			chs = append(chs, c)
+		}
+	}
+
+	if len(chs) == 0 {
+		return nil
+	}
+
+	if p.opts.DriftMode == DriftRecreate {
+		return p.applyDrift(s, id, sid, cfg, chs)
+	}
+
+	return &DriftError{
+		Changes:      chs,
+		Subscription: sid,
+	}
+}
+
+// applyDrift updates the changed fields of the existing subscription, or
+// recreates it when the filter or topic cannot be updated.
+func (p *PubSub) applyDrift(s Subscription, id string, sid string, cfg pubsub.SubscriptionConfig, chs []Change) error {
+	for _, c := range chs {
+		if !immutableFields[c.Field] {
+			continue
+		}
+
+		if err := s.Delete(p.ctx); err != nil {
+			return err
+		}
+		if err := p.clnt.CreateSubscription(p.ctx, sid, id, cfg); err != nil {
+			return err
+		}
+
+		p.log(p.ctx, slog.LevelWarn, "recreated subscription with a different configuration", slog.String("topic", id), slog.String("subscription", sid), slog.Any("changes", chs))
+		return nil
+	}
+
+	if err := s.Update(p.ctx, subscriptionUpdate(withSubscriptionDefaults(cfg), chs)); err != nil {
+		return err
+	}
+
+	p.log(p.ctx, slog.LevelInfo, "updated subscription with a different configuration", slog.String("topic", id), slog.String("subscription", sid), slog.Any("changes", chs))
+	return nil
+}
+
+// compareSubscription returns the fields of the existing subscription that
+// differ from the configuration, in alphabetical order. The configuration
+// should have the defaults of Google Cloud Pub/Sub applied.
+func compareSubscription(cur SubscriptionConfig, tid string, want pubsub.SubscriptionConfig) []Change {
+	var chs []Change
+
+	if cur.AckDeadline != want.AckDeadline {
+		chs = append(chs, Change{"ackDeadline", cur.AckDeadline.String(), want.AckDeadline.String()})
+	}
+	if !deadLettersEqual(cur.DeadLetterPolicy, want.DeadLetterPolicy) {
+		chs = append(chs, Change{"deadLetter", formatDeadLetter(cur.DeadLetterPolicy), formatDeadLetter(want.DeadLetterPolicy)})
+	}
+	if cur.Filter != want.Filter {
+		chs = append(chs, Change{"filter", strconv.Quote(cur.Filter), strconv.Quote(want.Filter)})
+	}
+	if !labelsEqual(cur.Labels, want.Labels) {
+		chs = append(chs, Change{"labels", formatLabels(cur.Labels), formatLabels(want.Labels)})
+	}
+	if cur.RetainAckedMessages != want.RetainAckedMessages {
+		chs = append(chs, Change{"retainAcked", fmt.Sprint(cur.RetainAckedMessages), fmt.Sprint(want.RetainAckedMessages)})
+	}
+	if cur.RetentionDuration != want.RetentionDuration {
+		chs = append(chs, Change{"retention", cur.RetentionDuration.String(), want.RetentionDuration.String()})
+	}
+	if !retryPoliciesEqual(cur.RetryPolicy, want.RetryPolicy) {
+		chs = append(chs, Change{"retry", formatRetryPolicy(cur.RetryPolicy), formatRetryPolicy(want.RetryPolicy)})
+	}
+	if cur.TopicID != tid {
+		chs = append(chs, Change{"topic", cur.TopicID, tid})
+	}
+
+	return chs
+}
+
+// subscriptionUpdate returns the update that applies the changed fields of
+// the configuration. Fields that cannot be updated are ignored.
+func subscriptionUpdate(want pubsub.SubscriptionConfig, chs []Change) pubsub.SubscriptionConfigToUpdate {
+	upd := pubsub.SubscriptionConfigToUpdate{}
+	for _, c := range chs {
+		switch c.Field {
+		case "ackDeadline":
+			upd.AckDeadline = want.AckDeadline
+		case "deadLetter":
+			// the zero value removes dead lettering
+			upd.DeadLetterPolicy = &pubsub.DeadLetterPolicy{}
+			if want.DeadLetterPolicy != nil {
+				upd.DeadLetterPolicy = want.DeadLetterPolicy
+			}
+		case "labels":
+			upd.Labels = mergeMaps(want.Labels)
+		case "retainAcked":
+			upd.RetainAckedMessages = want.RetainAckedMessages
+		case "retention":
+			upd.RetentionDuration = want.RetentionDuration
+		case "retry":
+			// the zero value removes the retry policy
+			upd.RetryPolicy = &pubsub.RetryPolicy{}
+			if want.RetryPolicy != nil {
+				upd.RetryPolicy = want.RetryPolicy
+			}
+		}
+	}
+
+	return upd
+}
+
+// withSubscriptionDefaults returns the configuration with the defaults of
+// Google Cloud Pub/Sub applied to the fields that are not set.
+func withSubscriptionDefaults(cfg pubsub.SubscriptionConfig) pubsub.SubscriptionConfig {
+	if cfg.AckDeadline == 0 {
+		cfg.AckDeadline = defaultAckDeadline
+	}
+	if cfg.RetentionDuration == 0 {
+		cfg.RetentionDuration = defaultRetentionDuration
+	}
+
+	if dlp := cfg.DeadLetterPolicy; dlp != nil {
+		cp := *dlp
+		if cp.MaxDeliveryAttempts == 0 {
+			cp.MaxDeliveryAttempts = defaultMaxDeliveryAttempts
+		}
+
+		cfg.DeadLetterPolicy = &cp
+	}
+
+	if rp := cfg.RetryPolicy; rp != nil {
+		cp := *rp
+		if optionalDuration(cp.MinimumBackoff) == 0 {
+			cp.MinimumBackoff = defaultMinimumBackoff
+		}
+		if optionalDuration(cp.MaximumBackoff) == 0 {
+			cp.MaximumBackoff = defaultMaximumBackoff
+		}
+
+		cfg.RetryPolicy = &cp
+	}
+
+	return cfg
+}
+
+func labelsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatLabels(lbls map[string]string) string {
+	kvs := make([]string, 0, len(lbls))
+	for k, v := range lbls {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+
+	return "{" + strings.Join(kvs, ",") + "}"
+}
+
+func deadLettersEqual(a *pubsub.DeadLetterPolicy, b *pubsub.DeadLetterPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return resourceID(a.DeadLetterTopic) == resourceID(b.DeadLetterTopic) &&
+		a.MaxDeliveryAttempts == b.MaxDeliveryAttempts
+}
+
+func formatDeadLetter(dlp *pubsub.DeadLetterPolicy) string {
+	if dlp == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s after %d attempts", resourceID(dlp.DeadLetterTopic), dlp.MaxDeliveryAttempts)
+}
+
+func retryPoliciesEqual(a *pubsub.RetryPolicy, b *pubsub.RetryPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return optionalDuration(a.MinimumBackoff) == optionalDuration(b.MinimumBackoff) &&
+		optionalDuration(a.MaximumBackoff) == optionalDuration(b.MaximumBackoff)
+}
+
+func formatRetryPolicy(rp *pubsub.RetryPolicy) string {
+	if rp == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s to %s", optionalDuration(rp.MinimumBackoff), optionalDuration(rp.MaximumBackoff))
+}
+
+// optionalDuration returns the value of an optional duration, or zero if it is
+// not set.
+func optionalDuration(v any) time.Duration {
+	d, _ := v.(time.Duration)
+	return d
+}
+
+// resourceID returns the last segment of a fully qualified resource name.
+func resourceID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,143 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub_CreateSubscription_Drift(t *testing.T) {
+	type args struct {
+		id   string
+		fltr string
+		cfg  pubsub.SubscriptionConfig
+	}
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		{
+			"should accept the same configuration",
+			args{"topic", `attributes.region = "CA"`, pubsub.SubscriptionConfig{AckDeadline: 10 * time.Second}},
+			nil,
+		},
+		{
+			"should report a different filter",
+			args{"topic", `attributes.region = "NY"`, pubsub.SubscriptionConfig{}},
+			[]string{"filter"},
+		},
+		{
+			"should report a different topic and ack deadline",
+			args{"other", `attributes.region = "CA"`, pubsub.SubscriptionConfig{AckDeadline: time.Minute}},
+			[]string{"ackDeadline", "topic"},
+		},
+		{
+			"should report a different retry policy",
+			args{"topic", `attributes.region = "CA"`, pubsub.SubscriptionConfig{RetryPolicy: &pubsub.RetryPolicy{}}},
+			[]string{"retry"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, ps *PubSub) {
+				ps.opts.SetDriftMode(DriftFail)
+				for _, id := range []string{"topic", "other"} {
+					if err := ps.CreateTopic(id); err != nil {
+						t.Fatalf("CreateTopic() error = %v", err)
+					}
+				}
+				if err := ps.CreateSubscription("topic", "sub", `attributes.region = "CA"`); err != nil {
+					t.Fatalf("CreateSubscription() error = %v", err)
+				}
+
+				err := ps.CreateSubscription(tt.args.id, "sub", tt.args.fltr, tt.args.cfg)
+
+				var got []string
+				var de *DriftError
+				if errors.As(err, &de) {
+					for _, c := range de.Changes {
+						got = append(got, c.Field)
+					}
+				} else if err != nil {
+					t.Fatalf("CreateSubscription() error = %v", err)
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("CreateSubscription() drifted fields = %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestPubSub_CreateSubscription_DriftMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode DriftMode
+		want string
+	}{
+		{"should keep the existing filter by default", 0, `attributes.region = "CA"`},
+		{"should keep the existing filter when ignoring drift", DriftIgnore, `attributes.region = "CA"`},
+		{"should replace the filter when recreating", DriftRecreate, `attributes.region = "NY"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			ps.opts.SetDriftMode(tt.mode)
+
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatalf("CreateTopic() error = %v", err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", `attributes.region = "CA"`); err != nil {
+				t.Fatalf("CreateSubscription() error = %v", err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", `attributes.region = "NY"`); err != nil {
+				t.Fatalf("CreateSubscription() error = %v", err)
+			}
+
+			cfg, err := ps.clnt.Subscription("sub").Config(context.Background())
+			if err != nil {
+				t.Fatalf("Config() error = %v", err)
+			}
+			if cfg.Filter != tt.want {
+				t.Errorf("Config().Filter = %q, want %q", cfg.Filter, tt.want)
+			}
+		})
+	}
+}
+
+func TestPubSub_CreateSubscription_DriftUpdate(t *testing.T) {
+	ps := newMemoryPubSub(t)
+	ps.opts.SetDriftMode(DriftRecreate)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatalf("CreateTopic() error = %v", err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+	if err := ps.Publish("topic", []byte("hello world")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	cfg := pubsub.SubscriptionConfig{AckDeadline: 30 * time.Second, RetryPolicy: &pubsub.RetryPolicy{}}
+	if err := ps.CreateSubscription("topic", "sub", "", cfg); err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+
+	cur, err := ps.clnt.Subscription("sub").Config(context.Background())
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cur.AckDeadline != 30*time.Second || cur.RetryPolicy == nil {
+		t.Errorf("Config() = %v and %v, want the ack deadline and retry policy to be updated", cur.AckDeadline, formatRetryPolicy(cur.RetryPolicy))
+	}
+
+	// updating the subscription in place keeps its messages
+	receiveN(t, ps, "sub", 1, ack)
+}
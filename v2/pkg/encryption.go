@@ -0,0 +1,229 @@
+package pb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+const (
+	// EncryptionKeyAttribute is the message attribute that records the ID of
+	// the key that encrypted the data key of the message.
+	EncryptionKeyAttribute = "Encryption-Key-ID"
+
+	// EncryptionDataKeyAttribute is the message attribute that holds the data
+	// key of the message, encrypted with the key and base64 encoded.
+	EncryptionDataKeyAttribute = "Encryption-Data-Key"
+)
+
+// dataKeySize is the size of the AES-256 key generated for every message.
+const dataKeySize = 32
+
+// ErrUnknownKey is returned by a KeyProvider for key IDs it does not have.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider provides the AES keys that encrypt the data keys of messages.
+// CurrentKey returns the key that new messages are encrypted with, and Key
+// returns the key with the ID, or ErrUnknownKey, to decrypt them.
+type KeyProvider interface {
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider with keys held in memory, for tests and
+// keys loaded from configuration. Rotate adds a key and makes it the current
+// key, while messages encrypted with the previous keys can still be
+// decrypted.
+type StaticKeyProvider struct {
+	cur  string
+	keys map[string][]byte
+	mu   sync.RWMutex
+}
+
+// NewStaticKeyProvider returns a StaticKeyProvider that encrypts with the key
+// with the ID. The keys must be 16, 24 or 32 bytes long.
+func NewStaticKeyProvider(id string, key []byte) (*StaticKeyProvider, error) {
+	kp := &StaticKeyProvider{keys: make(map[string][]byte)}
+	if err := kp.Rotate(id, key); err != nil {
+		return nil, err
+	}
+
+	return kp, nil
+}
+
+// Rotate adds the key with the ID and makes it the current key.
+func (kp *StaticKeyProvider) Rotate(id string, key []byte) error {
+	if id == "" {
+		return errors.New("key ID is empty")
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key %s: %w", id, err)
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	kp.keys[id] = key
+	kp.cur = id
+
+	return nil
+}
+
+// CurrentKey returns the ID and key that messages are encrypted with.
+func (kp *StaticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+
+	return kp.cur, kp.keys[kp.cur], nil
+}
+
+// Key returns the key with the ID, or ErrUnknownKey.
+func (kp *StaticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+
+	k, ok := kp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+
+	return k, nil
+}
+
+// Encrypt returns middleware that encrypts the data of every message with
+// AES-GCM under a new data key, which is itself encrypted with the current key
+// of the KeyProvider and stored in the EncryptionDataKeyAttribute along with
+// the key ID in the EncryptionKeyAttribute. The data is bound to the key ID and
+// the Content-Type and Content-Encoding attributes, so that messages fail to
+// decrypt when these are changed. It is applied after every other middleware
+// while a KeyProvider is set in PubSubOptions.
+func Encrypt(kp KeyProvider) PublishMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			kid, key, err := kp.CurrentKey(ctx)
+			if err != nil {
+				return FailedPublishResult(fmt.Errorf("getting encryption key: %w", err))
+			}
+
+			dk := make([]byte, dataKeySize)
+			if _, err := rand.Read(dk); err != nil {
+				return FailedPublishResult(err)
+			}
+
+			// bind the data key to the key ID, so that neither can be swapped
+			wrapped, err := seal(key, dk, []byte(kid))
+			if err != nil {
+				return FailedPublishResult(fmt.Errorf("encrypting data key with key %s: %w", kid, err))
+			}
+
+			d, err := seal(dk, m.Data, associatedData(kid, m.Attributes))
+			if err != nil {
+				return FailedPublishResult(fmt.Errorf("encrypting message: %w", err))
+			}
+
+			m.Data = d
+			m.Attributes[EncryptionKeyAttribute] = kid
+			m.Attributes[EncryptionDataKeyAttribute] = base64.StdEncoding.EncodeToString(wrapped)
+
+			return next(ctx, id, m)
+		}
+	}
+}
+
+// decrypt replaces the data of a message that has the
+// EncryptionKeyAttribute with the decrypted data, and removes the encryption
+// attributes. Without a KeyProvider the message is left encrypted, so that
+// tools without the keys can still inspect and forward it. It fails for
+// unknown keys and data that does not authenticate.
+func decrypt(ctx context.Context, kp KeyProvider, m *Message) error {
+	kid, ok := m.Attributes[EncryptionKeyAttribute]
+	if !ok || kp == nil {
+		return nil
+	}
+
+	key, err := kp.Key(ctx, kid)
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(m.Attributes[EncryptionDataKeyAttribute])
+	if err != nil {
+		return fmt.Errorf("invalid data key: %w", err)
+	}
+
+	dk, err := open(key, wrapped, []byte(kid))
+	if err != nil {
+		return fmt.Errorf("decrypting data key with key %s: %w", kid, err)
+	}
+
+	d, err := open(dk, m.Data, associatedData(kid, m.Attributes))
+	if err != nil {
+		return fmt.Errorf("decrypting message: %w", err)
+	}
+
+	m.Data = d
+	delete(m.Attributes, EncryptionKeyAttribute)
+	delete(m.Attributes, EncryptionDataKeyAttribute)
+
+	return nil
+}
+
+// associatedData returns the data that the encrypted data of a message is
+// bound to, each field prefixed with its length so that they cannot be
+// shifted between fields.
+func associatedData(kid string, attrs map[string]string) []byte {
+	var ad []byte
+	for _, f := range []string{kid, attrs[ContentTypeAttribute], attrs[ContentEncodingAttribute]} {
+		ad = binary.AppendUvarint(ad, uint64(len(f)))
+		ad = append(ad, f...)
+	}
+
+	return ad
+}
+
+// seal encrypts the plaintext with AES-GCM, prefixing it with a random nonce.
+func seal(key []byte, plaintext []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// open decrypts and authenticates the output of seal.
+func open(key []byte, ciphertext []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	n := gcm.NonceSize()
+	return gcm.Open(nil, ciphertext[:n], ciphertext[n:], ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(b)
+}
@@ -0,0 +1,287 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// testKey returns a 32 byte key filled with the byte.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// encryptMessage returns a message encrypted with the current key of the
+// provider, as it would be received.
+func encryptMessage(t *testing.T, kp KeyProvider, data []byte) *Message {
+	t.Helper()
+
+	var sent *pubsub.Message
+	res := Encrypt(kp)(func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+		sent = m
+		return resolvedPublishResult("1", nil)
+	})(context.Background(), "topic", &pubsub.Message{Data: data, Attributes: map[string]string{ContentTypeAttribute: "text/plain"}})
+	if _, err := res.Get(context.Background()); err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	return &Message{Data: sent.Data, Attributes: sent.Attributes}
+}
+
+func TestPubSub_KeyProvider(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// capture the messages as they are sent
+	var sent []*pubsub.Message
+	capture := func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			sent = append(sent, m)
+			return next(ctx, id, m)
+		}
+	}
+
+	ps := newMemoryPubSub(t)
+	ps.opts.SetKeyProvider(kp).SetPublishMiddleware(Compress(GzipCompressor, 0), capture)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// messages encrypted with the previous key can still be decrypted
+	data := []byte(strings.Repeat("jane.doe@example.com ", 20))
+	if err := ps.Publish("topic", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := kp.Rotate("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the middleware sees the compressed data before it is encrypted
+	for _, m := range sent {
+		if m.Attributes[ContentEncodingAttribute] != "gzip" {
+			t.Errorf("attributes = %v, want the data to be compressed", m.Attributes)
+		}
+	}
+
+	receiveN(t, ps, "sub", 2, func(ctx context.Context, m *Message) error {
+		if !bytes.Equal(m.Data, data) {
+			t.Errorf("received %q, want the original data", m.Data)
+		}
+		for _, k := range []string{EncryptionKeyAttribute, EncryptionDataKeyAttribute, ContentEncodingAttribute} {
+			if _, ok := m.Attributes[k]; ok {
+				t.Errorf("attributes = %v, want %s to be removed", m.Attributes, k)
+			}
+		}
+		return nil
+	})
+}
+
+func TestPubSub_WithoutKeyProvider(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent *pubsub.Message
+	capture := func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			sent = m
+			return next(ctx, id, m)
+		}
+	}
+
+	ps := newMemoryPubSub(t)
+	ps.opts.SetKeyProvider(kp).SetPublishMiddleware(Compress(GzipCompressor, 0), capture)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", []byte(strings.Repeat("jane.doe@example.com ", 20))); err != nil {
+		t.Fatal(err)
+	}
+	want := &pubsub.Message{Data: sent.Data, Attributes: map[string]string{}}
+	for k, v := range sent.Attributes {
+		want.Attributes[k] = v
+	}
+
+	// a client without the keys receives the message as it was sent
+	ps.opts.SetKeyProvider(nil)
+	receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+		if !bytes.Equal(m.Data, want.Data) {
+			t.Error("received data differs from the encrypted data that was sent")
+		}
+		for k, v := range want.Attributes {
+			if m.Attributes[k] != v {
+				t.Errorf("attributes = %v, want %v", m.Attributes, want.Attributes)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func TestPubSub_KeyProvider_Republished(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := newMemoryPubSub(t)
+	ps.opts.SetKeyProvider(kp)
+
+	for _, id := range []string{"topic", "other", "dlq"} {
+		if err := ps.CreateTopic(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.CreateSubscription(id, id+"-sub", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// copies of a message published to topic, published as is to the other
+	// topics, as if dead lettered from topic-sub or redriven to other
+	m := encryptMessage(t, kp, []byte("secret"))
+	ctx := context.Background()
+	copies := map[string]map[string]string{
+		"dlq":   {deadLetterSourceAttribute: "projects/test-project/subscriptions/topic-sub"},
+		"other": {},
+	}
+	for id, attrs := range copies {
+		res := ps.Backend().Topic(id).Publish(ctx, &pubsub.Message{Data: m.Data, Attributes: mergeMaps(m.Attributes, attrs)})
+		if _, err := res.Get(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// decrypting the messages does not depend on the topic they were sent to
+	for _, sid := range []string{"dlq-sub", "other-sub"} {
+		receiveN(t, ps, sid, 1, func(ctx context.Context, m *Message) error {
+			if string(m.Data) != "secret" {
+				t.Errorf("received %q from %s, want the message to be decrypted", m.Data, sid)
+			}
+			return nil
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := encryptMessage(t, kp, []byte("secret"))
+	if bytes.Contains(m.Data, []byte("secret")) {
+		t.Error("encrypted data contains the plaintext")
+	}
+	if m.Attributes[EncryptionKeyAttribute] != "k1" || m.Attributes[EncryptionDataKeyAttribute] == "" {
+		t.Errorf("attributes = %v, want the key ID and data key", m.Attributes)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	other, err := NewStaticKeyProvider("k2", testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := func(kp KeyProvider) KeyProvider { return kp }
+	untampered := func(m *Message) {}
+	authFailed := errors.New("authentication failed")
+
+	tests := []struct {
+		name    string
+		kp      func(KeyProvider) KeyProvider
+		tamper  func(m *Message)
+		wantErr error
+	}{
+		{
+			"should decrypt the message",
+			same,
+			untampered,
+			nil,
+		},
+		{
+			"should reject unknown key IDs",
+			func(KeyProvider) KeyProvider { return other },
+			untampered,
+			ErrUnknownKey,
+		},
+		{
+			"should reject tampered data",
+			same,
+			func(m *Message) { m.Data[len(m.Data)-1] ^= 1 },
+			authFailed,
+		},
+		{
+			"should reject a changed key ID",
+			same,
+			func(m *Message) { m.Attributes[EncryptionKeyAttribute] = "k1-copy" },
+			authFailed,
+		},
+		{
+			"should reject a changed Content-Type",
+			same,
+			func(m *Message) { m.Attributes[ContentTypeAttribute] = "application/json" },
+			authFailed,
+		},
+		{
+			"should reject an added Content-Encoding",
+			same,
+			func(m *Message) { m.Attributes[ContentEncodingAttribute] = "gzip" },
+			authFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// k1-copy holds the same key, so only the key ID differs
+			kp, err := NewStaticKeyProvider("k1-copy", testKey(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := kp.Rotate("k1", testKey(1)); err != nil {
+				t.Fatal(err)
+			}
+
+			m := encryptMessage(t, kp, []byte("secret"))
+			tt.tamper(m)
+
+			err = decrypt(context.Background(), tt.kp(kp), m)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("decrypt() error = %v", err)
+			case tt.wantErr == nil && string(m.Data) != "secret":
+				t.Errorf("decrypted %q, want secret", m.Data)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("decrypt() error = nil, want %v", tt.wantErr)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error()):
+				t.Errorf("decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	if _, err := NewStaticKeyProvider("k1", []byte("short")); err == nil {
+		t.Error("NewStaticKeyProvider() error = nil, want an invalid key error")
+	}
+	if _, err := NewStaticKeyProvider("", testKey(1)); err == nil {
+		t.Error("NewStaticKeyProvider() error = nil, want an empty key ID error")
+	}
+}
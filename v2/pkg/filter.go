@@ -0,0 +1,309 @@
+package pb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filter reports whether a message with the provided attributes matches a
+// subscription filter.
+type filter func(attrs map[string]string) bool
+
+// ParseFilter parses a Pub/Sub subscription filter into a function that
+// reports whether a message with the provided attributes matches it, so that
+// the same syntax can be used to select received messages.
+func ParseFilter(s string) (func(attrs map[string]string) bool, error) {
+	return parseFilter(s)
+}
+
+// parseFilter parses the Pub/Sub subscription filter syntax, which supports
+// attribute presence (attributes:key), equality (attributes.key = "value"),
+// inequality (attributes.key != "value"), hasPrefix(attributes.key, "prefix"),
+// NOT, AND, OR and parentheses. An empty filter matches every message.
+func parseFilter(s string) (filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return func(map[string]string) bool { return true }, nil
+	}
+
+	toks, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	fp := &filterParser{toks: toks}
+	f, err := fp.expr()
+	if err != nil {
+		return nil, err
+	}
+	if fp.pos < len(fp.toks) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", s, fp.toks[fp.pos].val)
+	}
+
+	return f, nil
+}
+
+type filterToken struct {
+	quoted bool
+	val    string
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var toks []filterToken
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '!' && i+1 < len(rs) && rs[i+1] == '=':
+			toks = append(toks, filterToken{val: "!="})
+			i += 2
+		case strings.ContainsRune("():=.,-", r):
+			toks = append(toks, filterToken{val: string(r)})
+			i++
+		case r == '"':
+			// find the closing quote, skipping escaped characters
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("invalid filter %q: unterminated string", s)
+			}
+
+			v, err := strconv.Unquote(string(rs[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter %q: %w", s, err)
+			}
+
+			toks = append(toks, filterToken{quoted: true, val: v})
+			i = j + 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			j := i + 1
+			for ; j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '-'); j++ {
+			}
+
+			toks = append(toks, filterToken{val: string(rs[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid filter %q: unexpected character %q", s, r)
+		}
+	}
+
+	return toks, nil
+}
+
+type filterParser struct {
+	pos  int
+	toks []filterToken
+}
+
+func (fp *filterParser) peek() (filterToken, bool) {
+	if fp.pos >= len(fp.toks) {
+		return filterToken{}, false
+	}
+
+	return fp.toks[fp.pos], true
+}
+
+// accept consumes the next token if it is the unquoted value v.
+func (fp *filterParser) accept(v string) bool {
+	if t, ok := fp.peek(); ok && !t.quoted && t.val == v {
+		fp.pos++
+		return true
+	}
+
+	return false
+}
+
+func (fp *filterParser) expect(v string) error {
+	if !fp.accept(v) {
+		return fp.unexpected(fmt.Sprintf("%q", v))
+	}
+
+	return nil
+}
+
+func (fp *filterParser) unexpected(want string) error {
+	t, ok := fp.peek()
+	if !ok {
+		return fmt.Errorf("invalid filter: expected %s but reached the end", want)
+	}
+
+	return fmt.Errorf("invalid filter: expected %s but found %q", want, t.val)
+}
+
+// key consumes an attribute key, which may be an identifier or a quoted string.
+func (fp *filterParser) key() (string, error) {
+	t, ok := fp.peek()
+	if !ok {
+		return "", fp.unexpected("an attribute key")
+	}
+
+	fp.pos++
+	return t.val, nil
+}
+
+// str consumes a quoted string value.
+func (fp *filterParser) str() (string, error) {
+	t, ok := fp.peek()
+	if !ok || !t.quoted {
+		return "", fp.unexpected("a quoted string")
+	}
+
+	fp.pos++
+	return t.val, nil
+}
+
+func (fp *filterParser) expr() (filter, error) {
+	l, err := fp.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for fp.accept("OR") {
+		r, err := fp.term()
+		if err != nil {
+			return nil, err
+		}
+
+		l = func(l, r filter) filter {
+			return func(attrs map[string]string) bool { return l(attrs) || r(attrs) }
+		}(l, r)
+	}
+
+	return l, nil
+}
+
+func (fp *filterParser) term() (filter, error) {
+	l, err := fp.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for fp.accept("AND") {
+		r, err := fp.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		l = func(l, r filter) filter {
+			return func(attrs map[string]string) bool { return l(attrs) && r(attrs) }
+		}(l, r)
+	}
+
+	return l, nil
+}
+
+func (fp *filterParser) factor() (filter, error) {
+	// negation
+	if fp.accept("NOT") || fp.accept("-") {
+		f, err := fp.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(attrs map[string]string) bool { return !f(attrs) }, nil
+	}
+
+	// grouping
+	if fp.accept("(") {
+		f, err := fp.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := fp.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return f, nil
+	}
+
+	// hasPrefix(attributes.key, "prefix")
+	if fp.accept("hasPrefix") {
+		if err := fp.expect("("); err != nil {
+			return nil, err
+		}
+		if err := fp.expect("attributes"); err != nil {
+			return nil, err
+		}
+		if err := fp.expect("."); err != nil {
+			return nil, err
+		}
+
+		k, err := fp.key()
+		if err != nil {
+			return nil, err
+		}
+		if err := fp.expect(","); err != nil {
+			return nil, err
+		}
+
+		pfx, err := fp.str()
+		if err != nil {
+			return nil, err
+		}
+		if err := fp.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return func(attrs map[string]string) bool {
+			v, ok := attrs[k]
+			return ok && strings.HasPrefix(v, pfx)
+		}, nil
+	}
+
+	return fp.comparison()
+}
+
+func (fp *filterParser) comparison() (filter, error) {
+	if err := fp.expect("attributes"); err != nil {
+		return nil, err
+	}
+
+	// attributes:key
+	if fp.accept(":") {
+		k, err := fp.key()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(attrs map[string]string) bool {
+			_, ok := attrs[k]
+			return ok
+		}, nil
+	}
+
+	// attributes.key = "value" or attributes.key != "value"
+	if err := fp.expect("."); err != nil {
+		return nil, err
+	}
+
+	k, err := fp.key()
+	if err != nil {
+		return nil, err
+	}
+
+	neq := fp.accept("!=")
+	if !neq {
+		if err := fp.expect("="); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := fp.str()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(attrs map[string]string) bool {
+		av, ok := attrs[k]
+		return ok && (av == v) != neq
+	}, nil
+}
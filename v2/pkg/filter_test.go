@@ -0,0 +1,52 @@
+package pb
+
+import "testing"
+
+func Test_parseFilter(t *testing.T) {
+	attrs := map[string]string{
+		"region": "CA",
+		"type":   "billboard-digital",
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		want    bool
+		wantErr bool
+	}{
+		{"should match everything when empty", "", true, false},
+		{"should match an equal attribute", `attributes.region = "CA"`, true, false},
+		{"should not match a different attribute", `attributes.region = "NV"`, false, false},
+		{"should match a not equal attribute", `attributes.region != "NV"`, true, false},
+		{"should not match a missing attribute with not equal", `attributes.missing != "NV"`, false, false},
+		{"should match attribute presence", `attributes:region`, true, false},
+		{"should not match missing attribute presence", `attributes:missing`, false, false},
+		{"should negate with NOT", `NOT attributes:missing`, true, false},
+		{"should negate with -", `-attributes:region`, false, false},
+		{"should match a prefix", `hasPrefix(attributes.type, "billboard")`, true, false},
+		{"should not match a different prefix", `hasPrefix(attributes.type, "transit")`, false, false},
+		{"should require both sides of AND", `attributes.region = "CA" AND attributes:missing`, false, false},
+		{"should require either side of OR", `attributes.region = "NV" OR attributes:type`, true, false},
+		{"should respect parentheses", `attributes:region AND (attributes:missing OR attributes.region = "CA")`, true, false},
+		{"should support quoted keys", `attributes."region" = "CA"`, true, false},
+		{"should return an error for a missing value", `attributes.region =`, false, true},
+		{"should return an error for an unquoted value", `attributes.region = CA`, false, true},
+		{"should return an error for an unterminated string", `attributes.region = "CA`, false, true},
+		{"should return an error for unbalanced parentheses", `(attributes:region`, false, true},
+		{"should return an error for trailing tokens", `attributes:region attributes:type`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := f(attrs); got != tt.want {
+				t.Errorf("parseFilter()(attrs) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
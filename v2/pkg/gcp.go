@@ -0,0 +1,236 @@
+package pb
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcpBackend is the Backend for Google Cloud Pub/Sub.
+type gcpBackend struct {
+	clnt *pubsub.Client
+	ctx  context.Context
+	opts []option.ClientOption
+	pID  string
+
+	// the schema client is only created when schemas are used
+	mu      sync.Mutex
+	schemas *pubsub.SchemaClient
+}
+
+func newGCPBackend(ctx context.Context, pID string, opts ...option.ClientOption) (*gcpBackend, error) {
+	clnt, err := pubsub.NewClient(ctx, pID, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcpBackend{
+		clnt: clnt,
+		ctx:  ctx,
+		opts: opts,
+		pID:  pID,
+	}, nil
+}
+
+func (b *gcpBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.schemas != nil {
+		if err := b.schemas.Close(); err != nil {
+			return err
+		}
+	}
+
+	return b.clnt.Close()
+}
+
+func (b *gcpBackend) schemaClient() (*pubsub.SchemaClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.schemas == nil {
+		sc, err := pubsub.NewSchemaClient(b.ctx, b.pID, b.opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		b.schemas = sc
+	}
+
+	return b.schemas, nil
+}
+
+func (b *gcpBackend) CreateSchema(ctx context.Context, id string, cfg pubsub.SchemaConfig) (*pubsub.SchemaConfig, error) {
+	sc, err := b.schemaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return sc.CreateSchema(ctx, id, cfg)
+}
+
+func (b *gcpBackend) Schema(ctx context.Context, id string) (*pubsub.SchemaConfig, error) {
+	sc, err := b.schemaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return sc.Schema(ctx, id, pubsub.SchemaViewFull)
+}
+
+func (b *gcpBackend) CreateSubscription(ctx context.Context, id string, tid string, cfg pubsub.SubscriptionConfig) error {
+	cfg.Topic = b.clnt.Topic(tid)
+	_, err := b.clnt.CreateSubscription(ctx, id, cfg)
+	return err
+}
+
+func (b *gcpBackend) CreateTopic(ctx context.Context, id string, cfg *pubsub.TopicConfig) error {
+	if cfg != nil {
+		_, err := b.clnt.CreateTopicWithConfig(ctx, id, cfg)
+		return err
+	}
+
+	_, err := b.clnt.CreateTopic(ctx, id)
+	return err
+}
+
+func (b *gcpBackend) Subscription(id string) Subscription {
+	return &gcpSubscription{b.clnt.Subscription(id)}
+}
+
+func (b *gcpBackend) Subscriptions(ctx context.Context) ([]string, error) {
+	var ids []string
+	it := b.clnt.Subscriptions(ctx)
+	for {
+		s, err := it.Next()
+		if err == iterator.Done {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, s.ID())
+	}
+}
+
+func (b *gcpBackend) Topic(id string) Topic {
+	return &gcpTopic{b.clnt.Topic(id)}
+}
+
+func (b *gcpBackend) Topics(ctx context.Context) ([]string, error) {
+	var ids []string
+	it := b.clnt.Topics(ctx)
+	for {
+		t, err := it.Next()
+		if err == iterator.Done {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, t.ID())
+	}
+}
+
+type gcpTopic struct {
+	t *pubsub.Topic
+}
+
+func (t *gcpTopic) Config(ctx context.Context) (pubsub.TopicConfig, error) {
+	return t.t.Config(ctx)
+}
+
+func (t *gcpTopic) Delete(ctx context.Context) error {
+	return t.t.Delete(ctx)
+}
+
+func (t *gcpTopic) Exists(ctx context.Context) (bool, error) {
+	return t.t.Exists(ctx)
+}
+
+func (t *gcpTopic) ID() string {
+	return t.t.ID()
+}
+
+func (t *gcpTopic) Publish(ctx context.Context, m *pubsub.Message) PublishResult {
+	return t.t.Publish(ctx, m)
+}
+
+func (t *gcpTopic) ResumePublish(key string) {
+	t.t.ResumePublish(key)
+}
+
+func (t *gcpTopic) SetMessageOrdering(enabled bool) {
+	t.t.EnableMessageOrdering = enabled
+}
+
+func (t *gcpTopic) SetPublishSettings(s pubsub.PublishSettings) {
+	t.t.PublishSettings = s
+}
+
+func (t *gcpTopic) Stop() {
+	t.t.Stop()
+}
+
+func (t *gcpTopic) String() string {
+	return t.t.String()
+}
+
+func (t *gcpTopic) Update(ctx context.Context, cfg pubsub.TopicConfigToUpdate) error {
+	_, err := t.t.Update(ctx, cfg)
+	return err
+}
+
+type gcpSubscription struct {
+	s *pubsub.Subscription
+}
+
+func (s *gcpSubscription) Config(ctx context.Context) (SubscriptionConfig, error) {
+	cfg, err := s.s.Config(ctx)
+	if err != nil {
+		return SubscriptionConfig{}, err
+	}
+
+	sc := SubscriptionConfig{SubscriptionConfig: cfg}
+	if cfg.Topic != nil {
+		sc.TopicID = cfg.Topic.ID()
+	}
+
+	return sc, nil
+}
+
+func (s *gcpSubscription) Delete(ctx context.Context) error {
+	return s.s.Delete(ctx)
+}
+
+func (s *gcpSubscription) Exists(ctx context.Context) (bool, error) {
+	return s.s.Exists(ctx)
+}
+
+func (s *gcpSubscription) ID() string {
+	return s.s.ID()
+}
+
+func (s *gcpSubscription) Receive(ctx context.Context, rs pubsub.ReceiveSettings, f func(context.Context, *Message)) error {
+	return s.receiveRaw(ctx, rs, func(ctx context.Context, m *pubsub.Message) {
+		f(ctx, newMessage(m))
+	})
+}
+
+func (s *gcpSubscription) Update(ctx context.Context, cfg pubsub.SubscriptionConfigToUpdate) error {
+	_, err := s.s.Update(ctx, cfg)
+	return err
+}
+
+// receiveRaw receives the underlying pubsub.Message values without wrapping
+// them, which is required to support PubSub.Receive.
+func (s *gcpSubscription) receiveRaw(ctx context.Context, rs pubsub.ReceiveSettings, f func(context.Context, *pubsub.Message)) error {
+	s.s.ReceiveSettings = rs
+	return s.s.Receive(ctx, f)
+}
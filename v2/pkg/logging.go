@@ -0,0 +1,108 @@
+package pb
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the values of the attributes redacted by RedactKeys.
+const Redacted = "[REDACTED]"
+
+// Redactor returns the value of a message attribute as it is written to the
+// log, so that sensitive values can be hidden or masked.
+type Redactor func(key string, value string) string
+
+// RedactKeys returns a Redactor that replaces the values of the attributes
+// with the keys, compared case-insensitively, with Redacted.
+func RedactKeys(keys ...string) Redactor {
+	ks := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		ks[strings.ToLower(k)] = true
+	}
+
+	return func(key string, value string) string {
+		if ks[strings.ToLower(key)] {
+			return Redacted
+		}
+
+		return value
+	}
+}
+
+// log writes the event to the Logger set in PubSubOptions, if any.
+func (p *PubSub) log(ctx context.Context, l slog.Level, msg string, attrs ...slog.Attr) {
+	if p.opts.Logger == nil {
+		return
+	}
+
+	p.opts.Logger.LogAttrs(ctx, l, msg, attrs...)
+}
+
+// logAttributes returns the message attributes as a group, with the values
+// passed through the LogRedactor set in PubSubOptions.
+func (p *PubSub) logAttributes(attrs map[string]string) slog.Attr {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	as := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := attrs[k]
+		if p.opts.LogRedactor != nil {
+			v = p.opts.LogRedactor(k, v)
+		}
+
+		as = append(as, slog.String(k, v))
+	}
+
+	return slog.Group("attributes", as...)
+}
+
+// loggedMessage logs the acks and nacks of a received message however it is
+// settled.
+func (p *PubSub) loggedMessage(m *Message) {
+	m.ackh = logAcker{
+		acker: m.ackh,
+		m:     m,
+		p:     p,
+	}
+}
+
+// logAcker logs the acks and nacks of a message.
+type logAcker struct {
+	acker
+	m *Message
+	p *PubSub
+}
+
+func (a logAcker) Ack() {
+	a.logSettled("acked message")
+	a.acker.Ack()
+}
+
+func (a logAcker) AckWithResult() AckResult {
+	a.logSettled("acked message")
+	return a.acker.AckWithResult()
+}
+
+func (a logAcker) Nack() {
+	a.logSettled("nacked message")
+	a.acker.Nack()
+}
+
+func (a logAcker) NackWithResult() AckResult {
+	a.logSettled("nacked message")
+	return a.acker.NackWithResult()
+}
+
+func (a logAcker) logSettled(msg string) {
+	a.p.log(context.Background(), slog.LevelDebug, msg,
+		slog.String("subscription", a.m.Subscription),
+		slog.String("message_id", a.m.ID),
+		a.p.logAttributes(a.m.Attributes),
+	)
+}
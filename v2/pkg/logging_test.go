@@ -0,0 +1,156 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// logBuffer collects the events written by a JSON logger.
+type logBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// events returns the events with the message.
+func (b *logBuffer) events(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var evs []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		var ev map[string]any
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev["msg"] == msg {
+			evs = append(evs, ev)
+		}
+	}
+
+	return evs
+}
+
+// waitForEvent returns the first event with the message once it is logged.
+func (b *logBuffer) waitForEvent(t *testing.T, msg string) map[string]any {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if evs := b.events(t, msg); len(evs) > 0 {
+			return evs[0]
+		}
+	}
+
+	t.Fatalf("no %q event was logged", msg)
+	return nil
+}
+
+func TestPubSub_Logger(t *testing.T) {
+	var logs logBuffer
+	l := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	opts := Options("test-project").
+		SetBackend(NewMemoryBackend()).
+		SetLogger(l).
+		SetLogRedactor(RedactKeys("Token"))
+	ps, err := NewPubSub(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := ps.CreateTopic("topic"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, msg := range []string{
+		"created topic",
+		"topic already exists, skipped creating it",
+		"created subscription",
+		"subscription already exists, skipped creating it",
+	} {
+		if evs := logs.events(t, msg); len(evs) != 1 {
+			t.Errorf("%d %q events, want 1", len(evs), msg)
+		}
+	}
+
+	// the attributes of failed messages should be logged with the redactor
+	if err := ps.Publish("missing", []byte("hello"), map[string]string{"token": "secret", "tenant": "a"}); err == nil {
+		t.Fatal("Publish() error = nil, want an error for a missing topic")
+	}
+	ev := logs.waitForEvent(t, "failed to publish message")
+	attrs, _ := ev["attributes"].(map[string]any)
+	if ev["level"] != "ERROR" || ev["topic"] != "missing" || ev["error"] == nil {
+		t.Errorf("publish failure event = %v, want the topic and error", ev)
+	}
+	if attrs["token"] != Redacted || attrs["tenant"] != "a" {
+		t.Errorf("publish failure attributes = %v, want the token redacted", attrs)
+	}
+
+	// fail the first delivery so that the message is nacked and redelivered
+	if err := ps.Publish("topic", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	for _, msg := range []string{
+		"receiving messages",
+		"failed to handle message",
+		"nacked message",
+		"acked message",
+		"stopped receiving messages",
+	} {
+		if ev := logs.waitForEvent(t, msg); ev["subscription"] != "sub" {
+			t.Errorf("%q event = %v, want the subscription", msg, ev)
+		}
+	}
+
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logs.waitForEvent(t, "closing client")
+	logs.waitForEvent(t, "closed client")
+}
+
+func TestRedactKeys(t *testing.T) {
+	r := RedactKeys("authorization", "Email")
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"Authorization", Redacted},
+		{"email", Redacted},
+		{"tenant", "value"},
+	}
+	for _, tt := range tests {
+		if got := r(tt.key, "value"); got != tt.want {
+			t.Errorf("RedactKeys()(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
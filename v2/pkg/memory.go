@@ -0,0 +1,860 @@
+package pb
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultAckDeadline is the ack deadline used by MemoryBackend subscriptions
+// that do not specify one, matching Google Cloud Pub/Sub.
+const defaultAckDeadline = 10 * time.Second
+
+// defaultRetentionDuration is the message retention duration used by
+// MemoryBackend subscriptions that do not specify one, matching Google Cloud
+// Pub/Sub.
+const defaultRetentionDuration = 7 * 24 * time.Hour
+
+// deletedTopic is the topic ID of subscriptions whose topic has been deleted,
+// matching Google Cloud Pub/Sub.
+const deletedTopic = "_deleted-topic_"
+
+// deadLetterSourceAttribute is the attribute Pub/Sub adds to dead lettered
+// messages with the subscription they were dead lettered from.
+const deadLetterSourceAttribute = "CloudPubSubDeadLetterSourceSubscription"
+
+// MemoryBackend is an in-process Backend that keeps all topics, subscriptions
+// and messages in memory, which makes it useful for unit tests and local
+// development without the Pub/Sub emulator.
+//
+// Published messages are delivered to every subscription of the topic whose
+// filter they match. Messages that are nacked are redelivered immediately, or
+// after the backoff of the subscription's RetryPolicy, and messages that are
+// neither acked nor nacked within the subscription's AckDeadline (10 seconds by
+// default) are redelivered once the deadline passes. The lease of a message is
+// extended while it is being handled, up to the MaxExtension of the
+// ReceiveSettings, as the Google Cloud Pub/Sub client does. Subscriptions with a
+// DeadLetterPolicy forward messages to the dead letter topic instead once they
+// have been delivered MaxDeliveryAttempts times. Subscriptions with
+// EnableMessageOrdering deliver messages with the same ordering key one at a
+// time, in the order they were published. Acks that arrive after the lease
+// has expired have no effect, and are reported as failed by AckWithResult for
+// subscriptions with EnableExactlyOnceDelivery.
+// Messages published to topics with a schema are rejected if they do not
+// conform to it. Errors use the same gRPC status codes as Google Cloud Pub/Sub.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	nextID  int
+	schemas map[string]*pubsub.SchemaConfig
+	subs    map[string]*memorySubscriptionState
+	topics  map[string]*memoryTopicState
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		schemas: make(map[string]*pubsub.SchemaConfig),
+		subs:    make(map[string]*memorySubscriptionState),
+		topics:  make(map[string]*memoryTopicState),
+	}
+}
+
+type memoryTopicState struct {
+	cfg       pubsub.TopicConfig
+	validator SchemaValidator
+}
+
+type memorySubscriptionState struct {
+	cfg     pubsub.SubscriptionConfig
+	filter  filter
+	leased  map[string]*memoryMessage
+	pending []*memoryMessage
+	signal  chan struct{}
+	topic   string
+}
+
+// notify wakes any receivers waiting for pending messages.
+func (s *memorySubscriptionState) notify() {
+	close(s.signal)
+	s.signal = make(chan struct{})
+}
+
+type memoryMessage struct {
+	attempts  int
+	deadline  time.Time
+	msg       pubsub.Message
+	notBefore time.Time
+}
+
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+func (b *MemoryBackend) CreateSubscription(ctx context.Context, id string, tid string, cfg pubsub.SubscriptionConfig) error {
+	f, err := parseFilter(cfg.Filter)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.topics[tid]; !ok {
+		return status.Errorf(codes.NotFound, "topic %s does not exist", tid)
+	}
+	if _, ok := b.subs[id]; ok {
+		return status.Errorf(codes.AlreadyExists, "subscription %s already exists", id)
+	}
+
+	// apply the defaults as Google Cloud Pub/Sub does
+	cfg = withSubscriptionDefaults(cfg)
+	cfg.Topic = nil
+
+	b.subs[id] = &memorySubscriptionState{
+		cfg:    cfg,
+		filter: f,
+		leased: make(map[string]*memoryMessage),
+		signal: make(chan struct{}),
+		topic:  tid,
+	}
+
+	return nil
+}
+
+func (b *MemoryBackend) CreateTopic(ctx context.Context, id string, cfg *pubsub.TopicConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.topics[id]; ok {
+		return status.Errorf(codes.AlreadyExists, "topic %s already exists", id)
+	}
+
+	ts := &memoryTopicState{}
+	if cfg != nil {
+		ts.cfg = *cfg
+	}
+
+	v, err := b.schemaValidator(ts.cfg.SchemaSettings)
+	if err != nil {
+		return err
+	}
+
+	ts.validator = v
+	b.topics[id] = ts
+
+	return nil
+}
+
+// schemaValidator returns the validator for messages published to a topic with
+// the schema settings, or nil if there are none; the caller must hold the lock.
+func (b *MemoryBackend) schemaValidator(ss *pubsub.SchemaSettings) (SchemaValidator, error) {
+	if ss == nil {
+		return nil, nil
+	}
+
+	sc, ok := b.schemas[ss.Schema]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "schema %s does not exist", ss.Schema)
+	}
+
+	v, err := NewSchemaValidator(*sc, ss.Encoding)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return v, nil
+}
+
+func (b *MemoryBackend) CreateSchema(ctx context.Context, id string, cfg pubsub.SchemaConfig) (*pubsub.SchemaConfig, error) {
+	if _, err := NewSchemaValidator(cfg, pubsub.EncodingBinary); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := memorySchemaName(id)
+	if _, ok := b.schemas[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "schema %s already exists", id)
+	}
+
+	cfg.Name = name
+	b.schemas[name] = &cfg
+
+	cp := cfg
+	return &cp, nil
+}
+
+func (b *MemoryBackend) Schema(ctx context.Context, id string) (*pubsub.SchemaConfig, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sc, ok := b.schemas[memorySchemaName(id)]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "schema %s does not exist", id)
+	}
+
+	cp := *sc
+	return &cp, nil
+}
+
+func memorySchemaName(id string) string {
+	return "schemas/" + id
+}
+
+func memoryTopicName(id string) string {
+	return "topics/" + id
+}
+
+func (b *MemoryBackend) Subscription(id string) Subscription {
+	return &memorySubscription{b: b, id: id}
+}
+
+func (b *MemoryBackend) Subscriptions(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ids := make([]string, 0, len(b.subs))
+	for id := range b.subs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (b *MemoryBackend) Topic(id string) Topic {
+	return &memoryTopic{b: b, id: id}
+}
+
+func (b *MemoryBackend) Topics(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ids := make([]string, 0, len(b.topics))
+	for id := range b.topics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// newID returns a new unique identifier; the caller must hold the lock.
+func (b *MemoryBackend) newID() string {
+	b.nextID++
+	return strconv.Itoa(b.nextID)
+}
+
+func (b *MemoryBackend) publish(tid string, m *pubsub.Message) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.deliver(tid, m)
+}
+
+// deliver adds the message to the subscriptions of the topic; the caller must
+// hold the lock.
+func (b *MemoryBackend) deliver(tid string, m *pubsub.Message) (string, error) {
+	ts, ok := b.topics[tid]
+	if !ok {
+		return "", status.Errorf(codes.NotFound, "topic %s does not exist", tid)
+	}
+
+	if ts.validator != nil {
+		if err := ts.validator.Validate(m.Data); err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "message does not conform to the schema of topic %s: %v", tid, err)
+		}
+	}
+
+	id := b.newID()
+	now := time.Now()
+
+	// fan out to each matching subscription of the topic
+	for _, s := range b.subs {
+		if s.topic != tid || !s.filter(m.Attributes) {
+			continue
+		}
+
+		s.pending = append(s.pending, &memoryMessage{
+			msg: pubsub.Message{
+				ID:          id,
+				Data:        m.Data,
+				Attributes:  mergeMaps(m.Attributes),
+				PublishTime: now,
+				OrderingKey: m.OrderingKey,
+			},
+		})
+		s.notify()
+	}
+
+	return id, nil
+}
+
+// next leases the next pending message of the subscription. When no message
+// is pending, it returns how long until the earliest lease expires (or a
+// negative duration if nothing is leased) and a channel that is closed when
+// messages become pending.
+func (b *MemoryBackend) next(sid string) (*Message, time.Duration, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.subs[sid]
+	if !ok {
+		return nil, 0, nil, status.Errorf(codes.NotFound, "subscription %s does not exist", sid)
+	}
+
+	// retry expired leases
+	now := time.Now()
+	wait := time.Duration(-1)
+	for aid, lm := range s.leased {
+		if !now.Before(lm.deadline) {
+			delete(s.leased, aid)
+			b.retry(sid, s, lm, now)
+			continue
+		}
+
+		wait = earliest(wait, lm.deadline.Sub(now))
+	}
+
+	// messages with an ordering key wait for the ones before them to be acked
+	var blocked map[string]bool
+	if s.cfg.EnableMessageOrdering {
+		blocked = make(map[string]bool)
+		for _, lm := range s.leased {
+			blocked[lm.msg.OrderingKey] = true
+		}
+	}
+
+	// find the oldest pending message that is not backing off
+	i := -1
+	for j, pm := range s.pending {
+		k := pm.msg.OrderingKey
+		if k != "" && blocked[k] {
+			continue
+		}
+		if now.Before(pm.notBefore) {
+			wait = earliest(wait, pm.notBefore.Sub(now))
+			if blocked != nil {
+				blocked[k] = true
+			}
+			continue
+		}
+
+		i = j
+		break
+	}
+
+	if i < 0 {
+		return nil, wait, s.signal, nil
+	}
+
+	// lease the message under a new ack ID
+	lm := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+
+	aid := b.newID()
+	lm.attempts++
+	lm.deadline = now.Add(s.cfg.AckDeadline)
+	s.leased[aid] = lm
+
+	m := &Message{
+		ID:          lm.msg.ID,
+		Data:        lm.msg.Data,
+		Attributes:  mergeMaps(lm.msg.Attributes),
+		PublishTime: lm.msg.PublishTime,
+		OrderingKey: lm.msg.OrderingKey,
+		ackh:        &memoryAcker{b: b, aid: aid, sid: sid},
+	}
+
+	// delivery attempts are only tracked with a dead letter policy
+	if s.cfg.DeadLetterPolicy != nil {
+		m.DeliveryAttempt = lm.attempts
+	}
+
+	return m, 0, nil, nil
+}
+
+// retry returns a message that was nacked or whose lease expired to the
+// pending messages of the subscription, or forwards it to the dead letter
+// topic once it has been delivered the maximum number of times; the caller
+// must hold the lock.
+func (b *MemoryBackend) retry(sid string, s *memorySubscriptionState, lm *memoryMessage, now time.Time) {
+	if dlp := s.cfg.DeadLetterPolicy; dlp != nil && lm.attempts >= dlp.MaxDeliveryAttempts {
+		// the message is dropped if the dead letter topic no longer exists
+		b.deliver(resourceID(dlp.DeadLetterTopic), &pubsub.Message{
+			Data: lm.msg.Data,
+			Attributes: mergeMaps(lm.msg.Attributes, map[string]string{
+				"CloudPubSubDeadLetterSourceDeliveryCount": strconv.Itoa(lm.attempts),
+				deadLetterSourceAttribute:                  sid,
+			}),
+			OrderingKey: lm.msg.OrderingKey,
+		})
+		return
+	}
+
+	lm.notBefore = now
+	if rp := s.cfg.RetryPolicy; rp != nil {
+		lm.notBefore = now.Add(backoff(rp, lm.attempts))
+	}
+
+	// redeliver the message before the later messages with its ordering key
+	i := len(s.pending)
+	if k := lm.msg.OrderingKey; s.cfg.EnableMessageOrdering && k != "" {
+		for j, pm := range s.pending {
+			if pm.msg.OrderingKey == k {
+				i = j
+				break
+			}
+		}
+	}
+
+	s.pending = append(s.pending[:i], append([]*memoryMessage{lm}, s.pending[i:]...)...)
+	s.notify()
+}
+
+// backoff returns the delay before the next delivery of a message that has
+// been delivered the number of times, doubling from the minimum backoff of the
+// retry policy up to its maximum.
+func backoff(rp *pubsub.RetryPolicy, attempts int) time.Duration {
+	d := optionalDuration(rp.MinimumBackoff)
+	max := optionalDuration(rp.MaximumBackoff)
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+// earliest returns the shorter of the durations, where a negative duration
+// means none.
+func earliest(a time.Duration, b time.Duration) time.Duration {
+	if a < 0 || b < a {
+		return b
+	}
+
+	return a
+}
+
+// extend extends the lease of a message by the subscription's AckDeadline, but
+// not past the limit, and returns how long to wait before extending it again.
+// It returns false once the lease has ended or can no longer be extended.
+func (b *MemoryBackend) extend(sid string, aid string, limit time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.subs[sid]
+	if !ok {
+		return 0, false
+	}
+	lm, ok := s.leased[aid]
+	if !ok {
+		return 0, false
+	}
+
+	// expired leases are left for next to redeliver
+	now := time.Now()
+	if !now.Before(lm.deadline) || !now.Before(limit) {
+		return 0, false
+	}
+
+	deadline := now.Add(s.cfg.AckDeadline)
+	if deadline.After(limit) {
+		deadline = limit
+	}
+	if deadline.After(lm.deadline) {
+		lm.deadline = deadline
+	}
+
+	return s.cfg.AckDeadline / 2, true
+}
+
+// settle acks or nacks a leased message. Settling an expired or unknown lease
+// has no effect, and is only reported as a failure for subscriptions with
+// exactly-once delivery, as Google Cloud Pub/Sub does.
+func (b *MemoryBackend) settle(sid string, aid string, ack bool) *ackResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.subs[sid]
+	if !ok {
+		return resolvedAckResult(pubsub.AcknowledgeStatusFailedPrecondition, status.Errorf(codes.NotFound, "subscription %s does not exist", sid))
+	}
+
+	invalid := resolvedAckResult(pubsub.AcknowledgeStatusSuccess, nil)
+	if s.cfg.EnableExactlyOnceDelivery {
+		invalid = resolvedAckResult(pubsub.AcknowledgeStatusInvalidAckID, status.Errorf(codes.InvalidArgument, "ack ID %s is invalid or has expired", aid))
+	}
+
+	lm, ok := s.leased[aid]
+	if !ok {
+		return invalid
+	}
+
+	delete(s.leased, aid)
+
+	// the lease expired, so the message is redelivered whether or not it is acked
+	now := time.Now()
+	if !now.Before(lm.deadline) {
+		b.retry(sid, s, lm, now)
+		return invalid
+	}
+
+	if !ack {
+		b.retry(sid, s, lm, now)
+		return resolvedAckResult(pubsub.AcknowledgeStatusSuccess, nil)
+	}
+
+	// release the next message with the ordering key
+	if s.cfg.EnableMessageOrdering && lm.msg.OrderingKey != "" {
+		s.notify()
+	}
+
+	return resolvedAckResult(pubsub.AcknowledgeStatusSuccess, nil)
+}
+
+type memoryAcker struct {
+	aid string
+	b   *MemoryBackend
+	sid string
+}
+
+func (a *memoryAcker) Ack() {
+	a.b.settle(a.sid, a.aid, true)
+}
+
+func (a *memoryAcker) AckWithResult() AckResult {
+	return a.b.settle(a.sid, a.aid, true)
+}
+
+func (a *memoryAcker) Nack() {
+	a.b.settle(a.sid, a.aid, false)
+}
+
+func (a *memoryAcker) NackWithResult() AckResult {
+	return a.b.settle(a.sid, a.aid, false)
+}
+
+type memoryTopic struct {
+	b  *MemoryBackend
+	id string
+}
+
+func (t *memoryTopic) Config(ctx context.Context) (pubsub.TopicConfig, error) {
+	t.b.mu.Lock()
+	defer t.b.mu.Unlock()
+
+	ts, ok := t.b.topics[t.id]
+	if !ok {
+		return pubsub.TopicConfig{}, status.Errorf(codes.NotFound, "topic %s does not exist", t.id)
+	}
+
+	cfg := ts.cfg
+	cfg.Labels = mergeMaps(ts.cfg.Labels)
+
+	return cfg, nil
+}
+
+func (t *memoryTopic) Delete(ctx context.Context) error {
+	t.b.mu.Lock()
+	defer t.b.mu.Unlock()
+
+	if _, ok := t.b.topics[t.id]; !ok {
+		return status.Errorf(codes.NotFound, "topic %s does not exist", t.id)
+	}
+
+	delete(t.b.topics, t.id)
+
+	// detach the subscriptions of the topic as Google Cloud Pub/Sub does
+	for _, s := range t.b.subs {
+		if s.topic == t.id {
+			s.topic = deletedTopic
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTopic) Exists(ctx context.Context) (bool, error) {
+	t.b.mu.Lock()
+	defer t.b.mu.Unlock()
+
+	_, ok := t.b.topics[t.id]
+	return ok, nil
+}
+
+func (t *memoryTopic) ID() string {
+	return t.id
+}
+
+func (t *memoryTopic) Publish(ctx context.Context, m *pubsub.Message) PublishResult {
+	return resolvedPublishResult(t.b.publish(t.id, m))
+}
+
+// ResumePublish has no effect, since messages are published synchronously and
+// a failure does not pause the ordering key.
+func (t *memoryTopic) ResumePublish(key string) {}
+
+func (t *memoryTopic) SetMessageOrdering(enabled bool) {}
+
+func (t *memoryTopic) SetPublishSettings(s pubsub.PublishSettings) {}
+
+func (t *memoryTopic) Stop() {}
+
+func (t *memoryTopic) String() string {
+	return memoryTopicName(t.id)
+}
+
+func (t *memoryTopic) Update(ctx context.Context, cfg pubsub.TopicConfigToUpdate) error {
+	t.b.mu.Lock()
+	defer t.b.mu.Unlock()
+
+	ts, ok := t.b.topics[t.id]
+	if !ok {
+		return status.Errorf(codes.NotFound, "topic %s does not exist", t.id)
+	}
+
+	if cfg.Labels != nil {
+		ts.cfg.Labels = mergeMaps(cfg.Labels)
+	}
+	if cfg.MessageStoragePolicy != nil {
+		ts.cfg.MessageStoragePolicy = *cfg.MessageStoragePolicy
+	}
+	if cfg.RetentionDuration != nil {
+		// a negative duration clears the retention
+		ts.cfg.RetentionDuration = cfg.RetentionDuration
+		if d, ok := cfg.RetentionDuration.(time.Duration); ok && d < 0 {
+			ts.cfg.RetentionDuration = nil
+		}
+	}
+	if ss := cfg.SchemaSettings; ss != nil {
+		// the zero value removes the schema
+		if *ss == (pubsub.SchemaSettings{}) {
+			ss = nil
+		}
+
+		v, err := t.b.schemaValidator(ss)
+		if err != nil {
+			return err
+		}
+
+		ts.cfg.SchemaSettings = ss
+		ts.validator = v
+	}
+
+	return nil
+}
+
+type memorySubscription struct {
+	b  *MemoryBackend
+	id string
+}
+
+func (s *memorySubscription) Config(ctx context.Context) (SubscriptionConfig, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	ss, ok := s.b.subs[s.id]
+	if !ok {
+		return SubscriptionConfig{}, status.Errorf(codes.NotFound, "subscription %s does not exist", s.id)
+	}
+
+	cfg := ss.cfg
+	cfg.Labels = mergeMaps(ss.cfg.Labels)
+
+	return SubscriptionConfig{
+		SubscriptionConfig: cfg,
+		TopicID:            ss.topic,
+	}, nil
+}
+
+func (s *memorySubscription) Delete(ctx context.Context) error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	ss, ok := s.b.subs[s.id]
+	if !ok {
+		return status.Errorf(codes.NotFound, "subscription %s does not exist", s.id)
+	}
+
+	delete(s.b.subs, s.id)
+
+	// wake any receivers so that they stop
+	ss.notify()
+
+	return nil
+}
+
+func (s *memorySubscription) Exists(ctx context.Context) (bool, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	_, ok := s.b.subs[s.id]
+	return ok, nil
+}
+
+func (s *memorySubscription) ID() string {
+	return s.id
+}
+
+func (s *memorySubscription) Receive(ctx context.Context, rs pubsub.ReceiveSettings, f func(context.Context, *Message)) error {
+	// bound the number of messages being handled at once
+	max := rs.MaxOutstandingMessages
+	if max <= 0 {
+		max = pubsub.DefaultReceiveSettings.MaxOutstandingMessages
+	}
+	sem := make(chan struct{}, max)
+
+	// wait for all handlers to return before returning
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sem <- struct{}{}:
+		}
+
+		m, err := s.wait(ctx)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return nil
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stop := s.keepAlive(m, rs.MaxExtension)
+			defer stop()
+
+			f(ctx, m)
+		}()
+	}
+}
+
+// keepAlive extends the lease of the message until the returned function is
+// called, or until max has passed since the message was received (60 minutes
+// when zero, and never when negative).
+func (s *memorySubscription) keepAlive(m *Message, max time.Duration) func() {
+	a, ok := m.ackh.(*memoryAcker)
+	if !ok || max < 0 {
+		return func() {}
+	}
+	if max == 0 {
+		max = pubsub.DefaultReceiveSettings.MaxExtension
+	}
+
+	limit := time.Now().Add(max)
+	done := make(chan struct{})
+	go func() {
+		var d time.Duration
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(d):
+			}
+
+			next, ok := s.b.extend(a.sid, a.aid, limit)
+			if !ok {
+				return
+			}
+			d = next
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (s *memorySubscription) Update(ctx context.Context, cfg pubsub.SubscriptionConfigToUpdate) error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	ss, ok := s.b.subs[s.id]
+	if !ok {
+		return status.Errorf(codes.NotFound, "subscription %s does not exist", s.id)
+	}
+
+	if cfg.AckDeadline != 0 {
+		ss.cfg.AckDeadline = cfg.AckDeadline
+	}
+	if cfg.DeadLetterPolicy != nil {
+		// the zero value removes dead lettering
+		ss.cfg.DeadLetterPolicy = cfg.DeadLetterPolicy
+		if *cfg.DeadLetterPolicy == (pubsub.DeadLetterPolicy{}) {
+			ss.cfg.DeadLetterPolicy = nil
+		}
+	}
+	if cfg.EnableExactlyOnceDelivery != nil {
+		ss.cfg.EnableExactlyOnceDelivery = cfg.EnableExactlyOnceDelivery.(bool)
+	}
+	if cfg.ExpirationPolicy != nil {
+		ss.cfg.ExpirationPolicy = cfg.ExpirationPolicy
+	}
+	if cfg.Labels != nil {
+		ss.cfg.Labels = mergeMaps(cfg.Labels)
+	}
+	if cfg.RetainAckedMessages != nil {
+		ss.cfg.RetainAckedMessages = cfg.RetainAckedMessages.(bool)
+	}
+	if cfg.RetentionDuration != 0 {
+		ss.cfg.RetentionDuration = cfg.RetentionDuration
+	}
+	if cfg.RetryPolicy != nil {
+		// the zero value removes the retry policy
+		ss.cfg.RetryPolicy = cfg.RetryPolicy
+		if *cfg.RetryPolicy == (pubsub.RetryPolicy{}) {
+			ss.cfg.RetryPolicy = nil
+		}
+	}
+
+	// apply the defaults to any new policies
+	ss.cfg = withSubscriptionDefaults(ss.cfg)
+
+	return nil
+}
+
+// wait blocks until a message can be leased, returning nil once the context
+// is done.
+func (s *memorySubscription) wait(ctx context.Context) (*Message, error) {
+	for {
+		m, wait, signal, err := s.b.next(s.id)
+		if err != nil || m != nil {
+			return m, err
+		}
+
+		// wake up when the earliest lease expires
+		var t *time.Timer
+		var expired <-chan time.Time
+		if wait >= 0 {
+			t = time.NewTimer(wait)
+			expired = t.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-signal:
+		case <-expired:
+		}
+
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
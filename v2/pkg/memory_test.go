@@ -0,0 +1,236 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newMemoryPubSub(t *testing.T) *PubSub {
+	t.Helper()
+
+	ps, err := NewPubSub(context.Background(), Options("test-project").SetBackend(NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+
+	return ps
+}
+
+// receiveN receives messages from the subscription until n have been handled
+// or the timeout elapses, returning the data of each handled message.
+func receiveN(t *testing.T, ps *PubSub, sid string, n int, h Handler) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mc := make(chan string, n)
+	err := ps.ReceiveFunc(ctx, sid, func(ctx context.Context, m *Message) error {
+		if err := h(ctx, m); err != nil {
+			return err
+		}
+
+		mc <- string(m.Data)
+		if len(mc) == n {
+			cancel()
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReceiveFunc() error = %v", err)
+	}
+	close(mc)
+
+	var got []string
+	for d := range mc {
+		got = append(got, d)
+	}
+	if len(got) != n {
+		t.Fatalf("received %d messages, want %d", len(got), n)
+	}
+
+	return got
+}
+
+func ack(context.Context, *Message) error { return nil }
+
+func TestMemoryBackend_FanOut(t *testing.T) {
+	ps := newMemoryPubSub(t)
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscriptions("topic", map[string]string{
+		"sub":    "",
+		"sub-ca": `attributes.region = "CA"`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.Publish("topic", []byte("ca"), map[string]string{"region": "CA"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", []byte("nv"), map[string]string{"region": "NV"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receiveN(t, ps, "sub", 2, ack); len(got) != 2 {
+		t.Errorf("sub received %v, want both messages", got)
+	}
+	if got := receiveN(t, ps, "sub-ca", 1, ack); got[0] != "ca" {
+		t.Errorf("sub-ca received %v, want [ca]", got)
+	}
+}
+
+func TestMemoryBackend_Redelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  pubsub.SubscriptionConfig
+		fail func(*Message)
+	}{
+		{
+			"should redeliver nacked messages",
+			pubsub.SubscriptionConfig{},
+			func(*Message) {},
+		},
+		{
+			"should redeliver messages when the ack deadline passes",
+			pubsub.SubscriptionConfig{AckDeadline: 50 * time.Millisecond},
+			func(m *Message) {
+				// ignore the nack so that the lease expires instead
+				m.ackh = nopAcker{}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", "", tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.Publish("topic", []byte("hello world")); err != nil {
+				t.Fatal(err)
+			}
+
+			var calls int32
+			receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					tt.fail(m)
+					return errors.New("failed")
+				}
+
+				return nil
+			})
+
+			if got := atomic.LoadInt32(&calls); got != 2 {
+				t.Errorf("message delivered %d times, want 2", got)
+			}
+		})
+	}
+}
+
+func TestMemoryBackend_LeaseExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		max  time.Duration
+		want int32
+	}{
+		{
+			"should extend the lease while the message is handled",
+			0,
+			1,
+		},
+		{
+			"should redeliver the message once the max extension passes",
+			60 * time.Millisecond,
+			2,
+		},
+		{
+			"should not extend the lease when the max extension is negative",
+			-1,
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			ps.opts.ReceiveSettings = pubsub.DefaultReceiveSettings
+			ps.opts.ReceiveSettings.MaxExtension = tt.max
+
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", "", pubsub.SubscriptionConfig{AckDeadline: 50 * time.Millisecond}); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.Publish("topic", []byte("hello world")); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// handle the first delivery for longer than the ack deadline
+			var calls int32
+			err := ps.ReceiveFunc(ctx, "sub", func(ctx context.Context, m *Message) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					time.Sleep(200 * time.Millisecond)
+					cancel()
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ReceiveFunc() error = %v", err)
+			}
+
+			if got := atomic.LoadInt32(&calls); got != tt.want {
+				t.Errorf("message delivered %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+type nopAcker struct{}
+
+func (nopAcker) Ack()  {}
+func (nopAcker) Nack() {}
+
+func (nopAcker) AckWithResult() AckResult {
+	return resolvedAckResult(pubsub.AcknowledgeStatusSuccess, nil)
+}
+
+func (nopAcker) NackWithResult() AckResult {
+	return resolvedAckResult(pubsub.AcknowledgeStatusSuccess, nil)
+}
+
+func TestMemoryBackend_Errors(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	if err := ps.Publish("missing", "hello world"); status.Code(err) != codes.NotFound {
+		t.Errorf("Publish() error = %v, want NotFound", err)
+	}
+	if err := ps.CreateSubscription("missing", "sub", ""); status.Code(err) != codes.NotFound {
+		t.Errorf("CreateSubscription() error = %v, want NotFound", err)
+	}
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", "attributes.region ="); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSubscription() error = %v, want InvalidArgument", err)
+	}
+	if err := ps.Receive("sub", make(chan *pubsub.Message)); !errors.Is(err, ErrRawReceiveUnsupported) {
+		t.Errorf("Receive() error = %v, want ErrRawReceiveUnsupported", err)
+	}
+}
@@ -0,0 +1,159 @@
+package pb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Handler processes a single received message. Returning nil acknowledges the
+// message, returning an error nacks it so that it is redelivered.
+type Handler func(context.Context, *Message) error
+
+// Message is a message received from a subscription. DeliveryAttempt is the
+// number of times the message has been delivered, and is only set for
+// subscriptions with a dead letter policy. Subscription is the ID of the
+// subscription the message was received from.
+type Message struct {
+	ID              string
+	Data            []byte
+	Attributes      map[string]string
+	PublishTime     time.Time
+	OrderingKey     string
+	DeliveryAttempt int
+	Subscription    string
+
+	ackh  acker
+	codec Codec
+}
+
+type acker interface {
+	Ack()
+	AckWithResult() AckResult
+	Nack()
+	NackWithResult() AckResult
+}
+
+// gcpAcker acks a message received from Google Cloud Pub/Sub.
+type gcpAcker struct {
+	m *pubsub.Message
+}
+
+func (a gcpAcker) Ack() {
+	a.m.Ack()
+}
+
+func (a gcpAcker) AckWithResult() AckResult {
+	return a.m.AckWithResult()
+}
+
+func (a gcpAcker) Nack() {
+	a.m.Nack()
+}
+
+func (a gcpAcker) NackWithResult() AckResult {
+	return a.m.NackWithResult()
+}
+
+func newMessage(m *pubsub.Message) *Message {
+	msg := &Message{
+		ID:          m.ID,
+		Data:        m.Data,
+		Attributes:  m.Attributes,
+		PublishTime: m.PublishTime,
+		OrderingKey: m.OrderingKey,
+		ackh:        gcpAcker{m},
+	}
+
+	if m.DeliveryAttempt != nil {
+		msg.DeliveryAttempt = *m.DeliveryAttempt
+	}
+
+	return msg
+}
+
+// Ack acknowledges the message.
+func (m *Message) Ack() {
+	m.ackh.Ack()
+}
+
+// AckWithResult acknowledges the message and returns the AckResult that
+// confirms it. With exactly-once delivery, a successful result guarantees that
+// the message will not be redelivered.
+func (m *Message) AckWithResult() AckResult {
+	return m.ackh.AckWithResult()
+}
+
+// Nack negatively acknowledges the message so that it is redelivered.
+func (m *Message) Nack() {
+	m.ackh.Nack()
+}
+
+// NackWithResult negatively acknowledges the message and returns the AckResult
+// that confirms it.
+func (m *Message) NackWithResult() AckResult {
+	return m.ackh.NackWithResult()
+}
+
+// OriginatedAt returns the time recorded in the message's OriginatedAt
+// attribute, and false when the attribute is missing or invalid.
+func (m *Message) OriginatedAt() (time.Time, bool) {
+	v, ok := m.Attributes[OriginatedAtAttribute]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0), true
+}
+
+// Decode unmarshals the message data into v using the codec registered for the
+// message's Content-Type attribute. Messages without the attribute are decoded
+// with the codec set in PubSubOptions, or as JSON if none is set. Decoding into
+// a *[]byte always returns the data as is.
+func (m *Message) Decode(v any) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = m.Data
+		return nil
+	}
+
+	// prefer the codec the message was published with
+	c := m.codec
+	if ct, ok := m.Attributes[ContentTypeAttribute]; ok {
+		rc, ok := CodecFor(ct)
+		if !ok {
+			return fmt.Errorf("no codec is registered for content type %s", ct)
+		}
+
+		c = rc
+	}
+
+	if c == nil {
+		c = JSONCodec
+	}
+
+	return c.Unmarshal(m.Data, v)
+}
+
+// PanicError is the error reported when a Handler panics while processing a
+// message.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// handle calls the handler and converts any panic into a PanicError.
+func handle(ctx context.Context, h Handler, m *Message) error {
+	return Recover()(h)(ctx, m)
+}
@@ -0,0 +1,187 @@
+package pb
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a prometheus.Collector of the messages published and received by
+// the PubSub clients it is set on with SetMetrics. Register it with a
+// prometheus.Registerer once, and share it between clients.
+type Metrics struct {
+	acked          *prometheus.CounterVec
+	endToEnd       *prometheus.HistogramVec
+	handlerSeconds *prometheus.HistogramVec
+	nacked         *prometheus.CounterVec
+	publishErrors  *prometheus.CounterVec
+	publishSeconds *prometheus.HistogramVec
+	published      *prometheus.CounterVec
+	publishedBytes *prometheus.CounterVec
+	received       *prometheus.CounterVec
+}
+
+// NewMetrics returns Metrics with the metric names prefixed by the namespace,
+// such as "pubsub_published_messages_total" for the "pubsub" namespace.
+func NewMetrics(namespace string) *Metrics {
+	topic := []string{"topic"}
+	sub := []string{"subscription"}
+
+	return &Metrics{
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "acked_messages_total",
+			Help:      "Number of received messages that were acked.",
+		}, sub),
+		endToEnd: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "end_to_end_latency_seconds",
+			Help:      "Time from when a message originated, according to its OriginatedAt attribute, until it was handled.",
+			Buckets:   []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		}, sub),
+		handlerSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time taken to handle a received message.",
+			Buckets:   prometheus.DefBuckets,
+		}, sub),
+		nacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nacked_messages_total",
+			Help:      "Number of received messages that were nacked.",
+		}, sub),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "publish_errors_total",
+			Help:      "Number of messages that failed to be published.",
+		}, topic),
+		publishSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken for a message to be published.",
+			Buckets:   prometheus.DefBuckets,
+		}, topic),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_messages_total",
+			Help:      "Number of messages that were published.",
+		}, topic),
+		publishedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_bytes_total",
+			Help:      "Size of the data of the messages that were published.",
+		}, topic),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "received_messages_total",
+			Help:      "Number of messages that were received.",
+		}, sub),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.acked,
+		m.endToEnd,
+		m.handlerSeconds,
+		m.nacked,
+		m.publishErrors,
+		m.publishSeconds,
+		m.published,
+		m.publishedBytes,
+		m.received,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// publishMiddleware records the messages sent to the topic, once they have been
+// published or have failed.
+func (m *Metrics) publishMiddleware(next PublishFunc) PublishFunc {
+	return func(ctx context.Context, id string, msg *pubsub.Message) PublishResult {
+		start := time.Now()
+		size := len(msg.Data)
+		res := next(ctx, id, msg)
+
+		onPublished(ctx, res, func() {
+			if _, err := res.Get(context.Background()); err != nil {
+				m.publishErrors.WithLabelValues(id).Inc()
+				return
+			}
+
+			m.published.WithLabelValues(id).Inc()
+			m.publishedBytes.WithLabelValues(id).Add(float64(size))
+			m.publishSeconds.WithLabelValues(id).Observe(time.Since(start).Seconds())
+		})
+
+		return res
+	}
+}
+
+// receiveMiddleware records how long messages take to be handled, and how long
+// after they originated they were handled.
+func (m *Metrics) receiveMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		start := time.Now()
+		err := next(ctx, msg)
+
+		m.handlerSeconds.WithLabelValues(msg.Subscription).Observe(time.Since(start).Seconds())
+		if t, ok := msg.OriginatedAt(); ok {
+			m.endToEnd.WithLabelValues(msg.Subscription).Observe(time.Since(t).Seconds())
+		}
+
+		return err
+	}
+}
+
+// receivedMessage records a message received from the subscription, and
+// counts the acks and nacks of the message however it is settled.
+func (m *Metrics) receivedMessage(msg *Message) {
+	m.received.WithLabelValues(msg.Subscription).Inc()
+	msg.ackh = metricsAcker{
+		acker: msg.ackh,
+		ack:   m.acked.WithLabelValues(msg.Subscription),
+		nack:  m.nacked.WithLabelValues(msg.Subscription),
+	}
+}
+
+// metricsAcker counts the acks and nacks of a message.
+type metricsAcker struct {
+	acker
+	ack  prometheus.Counter
+	nack prometheus.Counter
+}
+
+func (a metricsAcker) Ack() {
+	a.ack.Inc()
+	a.acker.Ack()
+}
+
+func (a metricsAcker) AckWithResult() AckResult {
+	a.ack.Inc()
+	return a.acker.AckWithResult()
+}
+
+func (a metricsAcker) Nack() {
+	a.nack.Inc()
+	a.acker.Nack()
+}
+
+func (a metricsAcker) NackWithResult() AckResult {
+	a.nack.Inc()
+	return a.acker.NackWithResult()
+}
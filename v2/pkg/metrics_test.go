@@ -0,0 +1,92 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitForCount waits for the counter to reach the value, since publishes are
+// recorded once their result is ready.
+func waitForCount(t *testing.T, c prometheus.Collector, want float64) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if testutil.ToFloat64(c) == want {
+			return
+		}
+	}
+
+	t.Errorf("counter = %v, want %v", testutil.ToFloat64(c), want)
+}
+
+func TestPubSub_Metrics(t *testing.T) {
+	m := NewMetrics("pubsub")
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	ps := newMemoryPubSub(t)
+	ps.opts.SetMetrics(m)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	originated := map[string]string{OriginatedAtAttribute: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}
+	for _, d := range []string{"hello", "world"} {
+		if err := ps.Publish("topic", []byte(d), originated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Publish("missing", []byte("hello")); err == nil {
+		t.Fatal("Publish() error = nil, want an error for a missing topic")
+	}
+
+	waitForCount(t, m.published.WithLabelValues("topic"), 2)
+	waitForCount(t, m.publishedBytes.WithLabelValues("topic"), 10)
+	waitForCount(t, m.publishErrors.WithLabelValues("missing"), 1)
+
+	// fail the first delivery so that a message is nacked and redelivered
+	var calls atomic.Int32
+	receiveN(t, ps, "sub", 2, func(ctx context.Context, msg *Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	if got := testutil.ToFloat64(m.received.WithLabelValues("sub")); got != 3 {
+		t.Errorf("received = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(m.acked.WithLabelValues("sub")); got != 2 {
+		t.Errorf("acked = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.nacked.WithLabelValues("sub")); got != 1 {
+		t.Errorf("nacked = %v, want 1", got)
+	}
+
+	// every metric should be gathered without errors
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("Gather() error = %v", err)
+	}
+	if n := testutil.CollectAndCount(m, "pubsub_handler_duration_seconds"); n != 1 {
+		t.Errorf("handler duration has %d series, want 1", n)
+	}
+	if n := testutil.CollectAndCount(m, "pubsub_end_to_end_latency_seconds"); n != 1 {
+		t.Errorf("end to end latency has %d series, want 1", n)
+	}
+	if n := testutil.CollectAndCount(m, "pubsub_publish_duration_seconds"); n != 1 {
+		t.Errorf("publish duration has %d series, want 1", n)
+	}
+}
@@ -0,0 +1,244 @@
+package pb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// OriginatedAtAttribute is the attribute that records when a message was first
+// published, in Unix seconds.
+const OriginatedAtAttribute = "OriginatedAt"
+
+// PublishFunc publishes a message to the topic with the ID. The message data
+// has already been marshalled, and is validated against the topic's schema
+// once it has passed through every middleware.
+type PublishFunc func(ctx context.Context, id string, m *pubsub.Message) PublishResult
+
+// PublishMiddleware wraps the publishing of every message, and may change the
+// message, such as by adding attributes or transforming its data, before
+// passing it to next, or return a failed PublishResult to reject it.
+type PublishMiddleware func(next PublishFunc) PublishFunc
+
+// ReceiveMiddleware wraps the handling of every received message, and may act
+// before and after calling next, change the context it is handled with, or
+// return an error without calling next so that the message is nacked.
+type ReceiveMiddleware func(next Handler) Handler
+
+// FailedPublishResult returns a PublishResult that fails with the error, for
+// middleware that rejects a message.
+func FailedPublishResult(err error) PublishResult {
+	return resolvedPublishResult("", err)
+}
+
+// publishCallbacksKey is the context key of the publishCallbacks of a message.
+type publishCallbacksKey struct{}
+
+// publishCallbacks collects the functions that run once a message has been
+// published, so that a single goroutine waits for the message instead of one
+// for every middleware that needs its result.
+type publishCallbacks struct {
+	fns     []func()
+	mu      sync.Mutex
+	started bool
+}
+
+// add runs f once the result is ready, on the goroutine started by run, or on
+// its own goroutine once run has been called.
+func (c *publishCallbacks) add(res PublishResult, f func()) {
+	wait := func() {
+		<-res.Ready()
+		f()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started {
+		go wait()
+		return
+	}
+
+	c.fns = append(c.fns, wait)
+}
+
+// run starts the goroutine that runs the functions in the order they were
+// added.
+func (c *publishCallbacks) run() {
+	c.mu.Lock()
+	fns := c.fns
+	c.started = true
+	c.mu.Unlock()
+
+	if len(fns) == 0 {
+		return
+	}
+
+	go func() {
+		for _, f := range fns {
+			f()
+		}
+	}()
+}
+
+// onPublished runs f once the result is ready. Messages published by PubSub
+// share a goroutine for every f, while middleware used on its own starts one.
+func onPublished(ctx context.Context, res PublishResult, f func()) {
+	if c, ok := ctx.Value(publishCallbacksKey{}).(*publishCallbacks); ok {
+		c.add(res, f)
+		return
+	}
+
+	go func() {
+		<-res.Ready()
+		f()
+	}()
+}
+
+// OriginatedAt returns middleware that sets the OriginatedAt attribute to the
+// current time for messages that do not already have it. It is used by default
+// while AutoOriginatedAt is set in PubSubOptions.
+func OriginatedAt() PublishMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			if _, ok := m.Attributes[OriginatedAtAttribute]; !ok {
+				m.Attributes[OriginatedAtAttribute] = fmt.Sprintf("%v", time.Now().Unix())
+			}
+
+			return next(ctx, id, m)
+		}
+	}
+}
+
+// publishChain wraps the publishing of messages to the topic with the
+// middleware set in PubSubOptions, the first being the outermost, preceded by
+// TracePublish when a TracerProvider is set and by OriginatedAt when
+// AutoOriginatedAt is set. Messages are encrypted after every middleware when a
+// KeyProvider is set, and then validated against the schema of the topic, and
+// the Metrics, when set, record the messages as they are sent to the topic.
+func (p *PubSub) publishChain(t Topic) PublishFunc {
+	f := func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+		return t.Publish(ctx, m)
+	}
+	if p.opts.Metrics != nil {
+		f = p.opts.Metrics.publishMiddleware(f)
+	}
+	f = p.validateMiddleware(f)
+	if p.opts.KeyProvider != nil {
+		f = Encrypt(p.opts.KeyProvider)(f)
+	}
+
+	for i := len(p.opts.PublishMiddleware) - 1; i >= 0; i-- {
+		f = p.opts.PublishMiddleware[i](f)
+	}
+	if p.opts.TracerProvider != nil {
+		f = TracePublish(p.opts.TracerProvider)(f)
+	}
+	if p.opts.AutoOriginatedAt {
+		f = OriginatedAt()(f)
+	}
+
+	return f
+}
+
+// Recover returns middleware that converts a panic in next into a PanicError.
+// Panics are always recovered before a message is settled, so it is only
+// needed for middleware placed before it to see the error.
+func Recover() ReceiveMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{
+						Value: r,
+						Stack: debug.Stack(),
+					}
+				}
+			}()
+
+			return next(ctx, m)
+		}
+	}
+}
+
+// Timeout returns middleware that cancels the context of next once the
+// duration has passed. Handlers must return when the context is done for the
+// message to be nacked on time.
+func Timeout(d time.Duration) ReceiveMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			return next(ctx, m)
+		}
+	}
+}
+
+// Logging returns middleware that logs the subscription, ID and handling time
+// of every message, at the debug level when next succeeds and at the error
+// level along with the error when it fails.
+func Logging(l *slog.Logger) ReceiveMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			start := time.Now()
+			err := next(ctx, m)
+
+			attrs := []slog.Attr{
+				slog.String("subscription", m.Subscription),
+				slog.String("message_id", m.ID),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				l.LogAttrs(ctx, slog.LevelError, "failed to handle message", append(attrs, slog.Any("error", err))...)
+				return err
+			}
+
+			l.LogAttrs(ctx, slog.LevelDebug, "handled message", attrs...)
+			return nil
+		}
+	}
+}
+
+// Latency returns middleware that calls observe with the time from when the
+// message originated, according to its OriginatedAt attribute, until next
+// returns. Messages without a valid OriginatedAt attribute are not observed.
+func Latency(observe func(m *Message, d time.Duration)) ReceiveMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			err := next(ctx, m)
+			if t, ok := m.OriginatedAt(); ok {
+				observe(m, time.Since(t))
+			}
+
+			return err
+		}
+	}
+}
+
+// receiveChain wraps the handler with the receive middleware set in
+// PubSubOptions, followed by the middleware set for the subscription, the
+// first being the outermost, and preceded by the Metrics when they are set and
+// by TraceReceive when a TracerProvider is set.
+func (p *PubSub) receiveChain(id string, h Handler) Handler {
+	sm := p.opts.SubscriptionMiddleware[id]
+	for i := len(sm) - 1; i >= 0; i-- {
+		h = sm[i](h)
+	}
+	for i := len(p.opts.ReceiveMiddleware) - 1; i >= 0; i-- {
+		h = p.opts.ReceiveMiddleware[i](h)
+	}
+	if p.opts.Metrics != nil {
+		h = p.opts.Metrics.receiveMiddleware(h)
+	}
+	if p.opts.TracerProvider != nil {
+		h = TraceReceive(p.opts.TracerProvider)(h)
+	}
+
+	return h
+}
@@ -0,0 +1,320 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// appendAttribute returns middleware that appends the value to the trail
+// attribute.
+func appendAttribute(v string) PublishMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			m.Attributes["trail"] += v
+			return next(ctx, id, m)
+		}
+	}
+}
+
+func TestPubSub_PublishMiddleware(t *testing.T) {
+	reject := func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			if m.Attributes["tenant"] == "" {
+				return FailedPublishResult(errors.New("missing tenant"))
+			}
+			return next(ctx, id, m)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		auto    bool
+		mws     []PublishMiddleware
+		attrs   map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			"should apply the middleware in order",
+			false,
+			[]PublishMiddleware{appendAttribute("a"), appendAttribute("b")},
+			map[string]string{},
+			map[string]string{"trail": "ab"},
+			false,
+		},
+		{
+			"should set OriginatedAt by default",
+			true,
+			nil,
+			map[string]string{},
+			map[string]string{OriginatedAtAttribute: ""},
+			false,
+		},
+		{
+			"should keep an existing OriginatedAt",
+			true,
+			nil,
+			map[string]string{OriginatedAtAttribute: "1700000000"},
+			map[string]string{OriginatedAtAttribute: "1700000000"},
+			false,
+		},
+		{
+			"should reject the message",
+			false,
+			[]PublishMiddleware{reject},
+			map[string]string{},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			ps.opts.SetAutoOriginatedAt(tt.auto).SetPublishMiddleware(tt.mws...)
+
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ps.Publish("topic", []byte("hello world"), tt.attrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got map[string]string
+			receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+				got = m.Attributes
+				return nil
+			})
+
+			if len(got) != len(tt.want) {
+				t.Errorf("attributes = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if _, ok := got[k]; !ok || (v != "" && got[k] != v) {
+					t.Errorf("attributes = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// appendTrail returns receive middleware that appends the value to the trail
+// before calling next.
+func appendTrail(mu *sync.Mutex, trail *string, v string) ReceiveMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			mu.Lock()
+			*trail += v
+			mu.Unlock()
+			return next(ctx, m)
+		}
+	}
+}
+
+func TestPubSub_ReceiveMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	var trail string
+
+	ps := newMemoryPubSub(t)
+	ps.opts.
+		SetReceiveMiddleware(appendTrail(&mu, &trail, "a"), appendTrail(&mu, &trail, "b")).
+		SetSubscriptionMiddleware("sub", appendTrail(&mu, &trail, "c")).
+		SetSubscriptionMiddleware("other", appendTrail(&mu, &trail, "x"))
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	var sub string
+	receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+		sub = m.Subscription
+		return nil
+	})
+
+	if trail != "abc" {
+		t.Errorf("middleware applied as %q, want %q", trail, "abc")
+	}
+	if sub != "sub" {
+		t.Errorf("Message.Subscription = %q, want sub", sub)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var got error
+	observe := func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			got = next(ctx, m)
+			return got
+		}
+	}
+
+	h := observe(Recover()(func(ctx context.Context, m *Message) error {
+		panic("boom")
+	}))
+
+	var pe *PanicError
+	if err := h(context.Background(), &Message{}); !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Errorf("handler error = %v, want a PanicError", err)
+	}
+	if !errors.As(got, &pe) {
+		t.Errorf("outer middleware saw %v, want a PanicError", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(10 * time.Millisecond)(func(ctx context.Context, m *Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := h(context.Background(), &Message{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("handler error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			"should log handled messages at the debug level",
+			nil,
+			[]string{"level=DEBUG", "subscription=sub", "message_id=1", "duration="},
+		},
+		{
+			"should log failed messages with the error",
+			errors.New("failed"),
+			[]string{"level=ERROR", "subscription=sub", "message_id=1", "error=failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			h := Logging(l)(func(ctx context.Context, m *Message) error {
+				return tt.err
+			})
+			if err := h(context.Background(), &Message{ID: "1", Subscription: "sub"}); err != tt.err {
+				t.Errorf("handler error = %v, want %v", err, tt.err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(buf.String(), w) {
+					t.Errorf("log = %q, want it to contain %q", buf.String(), w)
+				}
+			}
+		})
+	}
+}
+
+func TestLatency(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		want  bool
+	}{
+		{
+			"should observe the latency from OriginatedAt",
+			map[string]string{OriginatedAtAttribute: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+			true,
+		},
+		{
+			"should skip messages without OriginatedAt",
+			map[string]string{},
+			false,
+		},
+		{
+			"should skip messages with an invalid OriginatedAt",
+			map[string]string{OriginatedAtAttribute: "yesterday"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got time.Duration
+			var observed bool
+			h := Latency(func(m *Message, d time.Duration) {
+				got = d
+				observed = true
+			})(func(ctx context.Context, m *Message) error {
+				return nil
+			})
+
+			h(context.Background(), &Message{Attributes: tt.attrs})
+
+			if observed != tt.want {
+				t.Fatalf("observed = %v, want %v", observed, tt.want)
+			}
+			if observed && (got < 59*time.Second || got > 2*time.Minute) {
+				t.Errorf("latency = %v, want about a minute", got)
+			}
+		})
+	}
+}
+
+func TestOnPublished(t *testing.T) {
+	cbs := &publishCallbacks{}
+	ctx := context.WithValue(context.Background(), publishCallbacksKey{}, cbs)
+
+	var mu sync.Mutex
+	var got []string
+	done := make(chan struct{}, 3)
+	record := func(s string) func() {
+		return func() {
+			mu.Lock()
+			got = append(got, s)
+			mu.Unlock()
+			done <- struct{}{}
+		}
+	}
+
+	res := newPublishResult()
+	onPublished(ctx, res, record("metrics"))
+	onPublished(ctx, res, record("tracing"))
+
+	// the callbacks only run once they have been started and the message
+	// has been published
+	cbs.run()
+	onPublished(ctx, res, record("late"))
+	res.resolve("1", nil)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("callbacks were not run")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	order := strings.Join(got, ",")
+	if strings.Index(order, "metrics") > strings.Index(order, "tracing") {
+		t.Errorf("callbacks ran in order %v, want metrics before tracing", got)
+	}
+}
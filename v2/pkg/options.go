@@ -1,18 +1,34 @@
 package pb
 
 import (
+	"log/slog"
+	"time"
+
 	"cloud.google.com/go/pubsub"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/option"
 )
 
 // PubSubOptions provides a way to configure the PubSub client with various
 // options such as the project ID, client options, and publish and receive settings.
 type PubSubOptions struct {
-	AutoOriginatedAt bool
-	ProjectID        string
-	ClientOptions    []option.ClientOption
-	PublishSettings  pubsub.PublishSettings
-	ReceiveSettings  pubsub.ReceiveSettings
+	AutoOriginatedAt       bool
+	Backend                Backend
+	Codec                  Codec
+	DriftMode              DriftMode
+	KeyProvider            KeyProvider
+	LogRedactor            Redactor
+	Logger                 *slog.Logger
+	Metrics                *Metrics
+	ProjectID              string
+	ClientOptions          []option.ClientOption
+	PublishMiddleware      []PublishMiddleware
+	PublishSettings        pubsub.PublishSettings
+	ReceiveMiddleware      []ReceiveMiddleware
+	ReceiveSettings        pubsub.ReceiveSettings
+	ShutdownTimeout        time.Duration
+	SubscriptionMiddleware map[string][]ReceiveMiddleware
+	TracerProvider         trace.TracerProvider
 }
 
 // Options returns a new PubSubOptions struct with the provided project ID and
@@ -30,12 +46,79 @@ func Options(pID string, opts ...option.ClientOption) *PubSubOptions {
 // SetAutoOriginatedAt sets the AutoOriginatedAt field on the PubSubOptions struct
 // to the provided value and returns the modified PubSubOptions struct. If true,
 // the OriginatedAt attribute will be set to the current time if it is not already
-// set for all messages published.
+// set for all messages published, by the OriginatedAt middleware.
 func (o *PubSubOptions) SetAutoOriginatedAt(auto bool) *PubSubOptions {
 	o.AutoOriginatedAt = auto
 	return o
 }
 
+// SetBackend sets the Backend field on the PubSubOptions struct to the provided
+// backend and returns the modified PubSubOptions struct. When set, NewPubSub uses
+// the backend instead of connecting to Google Cloud Pub/Sub, and the ProjectID and
+// ClientOptions fields are ignored.
+func (o *PubSubOptions) SetBackend(b Backend) *PubSubOptions {
+	o.Backend = b
+	return o
+}
+
+// SetCodec sets the Codec field on the PubSubOptions struct to the provided codec
+// and returns the modified PubSubOptions struct. The codec is used to marshal data
+// passed to Publish (other than []byte values, which are published as is) and to
+// decode received messages that have no Content-Type attribute. JSONCodec is used
+// when no codec is set.
+func (o *PubSubOptions) SetCodec(c Codec) *PubSubOptions {
+	o.Codec = c
+	return o
+}
+
+// SetDriftMode sets the DriftMode field on the PubSubOptions struct to the provided
+// mode and returns the modified PubSubOptions struct. The mode controls whether
+// CreateSubscription returns a DriftError, ignores the difference, or updates or
+// recreates the subscription when it already exists with a different configuration.
+// DriftIgnore is used when no mode is set.
+func (o *PubSubOptions) SetDriftMode(m DriftMode) *PubSubOptions {
+	o.DriftMode = m
+	return o
+}
+
+// SetKeyProvider sets the KeyProvider field on the PubSubOptions struct to the
+// provided provider and returns the modified PubSubOptions struct. When set, the
+// data of every published message is encrypted with the Encrypt middleware, after
+// any other middleware, and received messages are decrypted before they are
+// handled. Received messages with an unknown key ID, or whose data fails to
+// authenticate, are nacked.
+func (o *PubSubOptions) SetKeyProvider(kp KeyProvider) *PubSubOptions {
+	o.KeyProvider = kp
+	return o
+}
+
+// SetLogRedactor sets the LogRedactor field on the PubSubOptions struct to the
+// provided redactor and returns the modified PubSubOptions struct. The values of
+// message attributes pass through the redactor before they are logged, such as
+// with RedactKeys to hide the values of sensitive keys.
+func (o *PubSubOptions) SetLogRedactor(r Redactor) *PubSubOptions {
+	o.LogRedactor = r
+	return o
+}
+
+// SetLogger sets the Logger field on the PubSubOptions struct to the provided
+// logger and returns the modified PubSubOptions struct. When set, the client logs
+// the topics and subscriptions it creates or skips, messages that fail to be
+// published, when receiving starts and stops, acks and nacks, and shutdown.
+func (o *PubSubOptions) SetLogger(l *slog.Logger) *PubSubOptions {
+	o.Logger = l
+	return o
+}
+
+// SetMetrics sets the Metrics field on the PubSubOptions struct to the provided
+// metrics and returns the modified PubSubOptions struct. When set, the messages
+// published and received are recorded in the metrics, which must be registered
+// with a prometheus.Registerer separately.
+func (o *PubSubOptions) SetMetrics(m *Metrics) *PubSubOptions {
+	o.Metrics = m
+	return o
+}
+
 // SetProjectID sets the ProjectID field on the PubSubOptions struct to the provided
 // value and returns the modified PubSubOptions struct.
 func (o *PubSubOptions) SetProjectID(pID string) *PubSubOptions {
@@ -55,6 +138,15 @@ func (o *PubSubOptions) SetClientOptions(opts ...option.ClientOption) *PubSubOpt
 	return o
 }
 
+// SetPublishMiddleware sets the PublishMiddleware field on the PubSubOptions struct
+// to the provided middleware and returns the modified PubSubOptions struct. Every
+// published message passes through the middleware in order, after the OriginatedAt
+// middleware that is applied while AutoOriginatedAt is true.
+func (o *PubSubOptions) SetPublishMiddleware(mws ...PublishMiddleware) *PubSubOptions {
+	o.PublishMiddleware = mws
+	return o
+}
+
 // SetPublishSettings sets the PublishSettings field on the PubSubOptions struct to
 // the provided settings and returns the modified PubSubOptions struct.
 func (o *PubSubOptions) SetPublishSettings(s pubsub.PublishSettings) *PubSubOptions {
@@ -62,9 +154,52 @@ func (o *PubSubOptions) SetPublishSettings(s pubsub.PublishSettings) *PubSubOpti
 	return o
 }
 
+// SetReceiveMiddleware sets the ReceiveMiddleware field on the PubSubOptions struct
+// to the provided middleware and returns the modified PubSubOptions struct. Every
+// message handled by ReceiveFunc, ReceiveOrdered, ReceiveConfirmed or a
+// TypedSubscriber passes through the middleware in order, before the middleware
+// set for its subscription.
+func (o *PubSubOptions) SetReceiveMiddleware(mws ...ReceiveMiddleware) *PubSubOptions {
+	o.ReceiveMiddleware = mws
+	return o
+}
+
 // SetReceiveSettings sets the ReceiveSettings field on the PubSubOptions struct to
 // the provided settings and returns the modified PubSubOptions struct.
 func (o *PubSubOptions) SetReceiveSettings(s pubsub.ReceiveSettings) *PubSubOptions {
 	o.ReceiveSettings = s
 	return o
 }
+
+// SetShutdownTimeout sets the ShutdownTimeout field on the PubSubOptions struct to
+// the provided duration and returns the modified PubSubOptions struct. Close waits
+// at most this long for messages that are still being published to be sent before
+// closing the client. 10 seconds is used when no timeout is set.
+func (o *PubSubOptions) SetShutdownTimeout(d time.Duration) *PubSubOptions {
+	o.ShutdownTimeout = d
+	return o
+}
+
+// SetSubscriptionMiddleware sets the middleware for the subscription in the
+// SubscriptionMiddleware field on the PubSubOptions struct and returns the modified
+// PubSubOptions struct. Messages received from the subscription pass through the
+// middleware in order, after the middleware set with SetReceiveMiddleware.
+func (o *PubSubOptions) SetSubscriptionMiddleware(id string, mws ...ReceiveMiddleware) *PubSubOptions {
+	if o.SubscriptionMiddleware == nil {
+		o.SubscriptionMiddleware = make(map[string][]ReceiveMiddleware)
+	}
+
+	o.SubscriptionMiddleware[id] = mws
+
+	return o
+}
+
+// SetTracerProvider sets the TracerProvider field on the PubSubOptions struct to
+// the provided provider and returns the modified PubSubOptions struct. When set,
+// the TracePublish and TraceReceive middleware are applied before any other
+// middleware, propagating the trace context of published messages in their
+// traceparent and tracestate attributes.
+func (o *PubSubOptions) SetTracerProvider(tp trace.TracerProvider) *PubSubOptions {
+	o.TracerProvider = tp
+	return o
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"google.golang.org/api/option"
@@ -88,6 +89,22 @@ func TestPubSubOptions_SetAutoOriginatedAt(t *testing.T) {
 	}
 }
 
+func TestPubSubOptions_SetBackend(t *testing.T) {
+	b := NewMemoryBackend()
+
+	o := &PubSubOptions{}
+	if got := o.SetBackend(b); got.Backend != b {
+		t.Errorf("SetBackend() = %v, want %v", got.Backend, b)
+	}
+}
+
+func TestPubSubOptions_SetShutdownTimeout(t *testing.T) {
+	o := &PubSubOptions{}
+	if got := o.SetShutdownTimeout(time.Minute); got.ShutdownTimeout != time.Minute {
+		t.Errorf("SetShutdownTimeout() = %v, want %v", got.ShutdownTimeout, time.Minute)
+	}
+}
+
 func TestPubSubOptions_SetProjectID(t *testing.T) {
 	type args struct {
 		pID string
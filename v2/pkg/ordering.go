@@ -0,0 +1,68 @@
+package pb
+
+import (
+	"context"
+	"sync"
+)
+
+// ReceiveOrdered subscribes to a topic via the subscription id and calls the
+// handler for each message in the same way as ReceiveFunc, except that the
+// handler is never called for a message while it is handling another message
+// with the same ordering key. Messages without an ordering key are handled
+// concurrently. Together with a subscription created with WithMessageOrdering,
+// this handles the messages of each key sequentially in the order they were
+// published.
+func (p *PubSub) ReceiveOrdered(ctx context.Context, id string, h Handler) error {
+	var kl keyLocks
+	h = p.receiveChain(id, h)
+	return p.receiveFunc(ctx, id, func(ctx context.Context, m *Message) error {
+		if m.OrderingKey == "" {
+			return h(ctx, m)
+		}
+
+		defer kl.lock(m.OrderingKey)()
+		return h(ctx, m)
+	})
+}
+
+// keyLocks holds a mutex per key for as long as it is in use.
+type keyLocks struct {
+	locks map[string]*keyLock
+	mu    sync.Mutex
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the mutex of the key and returns the function that unlocks it.
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		// forget the key once nothing is waiting for it
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
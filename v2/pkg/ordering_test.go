@@ -0,0 +1,139 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub_ReceiveOrdered(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		if err := ps.CreateTopic("topic"); err != nil {
+			t.Fatalf("CreateTopic() error = %v", err)
+		}
+		if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithMessageOrdering()); err != nil {
+			t.Fatalf("CreateSubscriptionWithOptions() error = %v", err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			for _, k := range []string{"a", "b"} {
+				if err := ps.PublishWithOrderingKey("topic", k, []byte(fmt.Sprintf("%s-%d", k, i))); err != nil {
+					t.Fatalf("PublishWithOrderingKey() error = %v", err)
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// fail the first delivery of a-1, which should be redelivered before a-2
+		var mu sync.Mutex
+		got := make(map[string][]string)
+		active := make(map[string]int)
+		failed := false
+		n := 0
+		err := ps.ReceiveOrdered(ctx, "sub", func(ctx context.Context, m *Message) error {
+			mu.Lock()
+			active[m.OrderingKey]++
+			if active[m.OrderingKey] > 1 {
+				t.Errorf("ReceiveOrdered() handled two messages with key %s at once", m.OrderingKey)
+			}
+			mu.Unlock()
+
+			// give a concurrent handler for the key a chance to start
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			active[m.OrderingKey]--
+			got[m.OrderingKey] = append(got[m.OrderingKey], string(m.Data))
+			if string(m.Data) == "a-1" && !failed {
+				failed = true
+				return errors.New("failed")
+			}
+
+			n++
+			if n == 6 {
+				cancel()
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReceiveOrdered() error = %v", err)
+		}
+
+		want := map[string][]string{
+			"a": {"a-1", "a-1", "a-2", "a-3"},
+			"b": {"b-1", "b-2", "b-3"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReceiveOrdered() handled %v, want %v", got, want)
+		}
+	})
+}
+
+// failingBackend returns topics that fail to publish the first message and
+// record the ordering keys that are resumed.
+type failingBackend struct {
+	Backend
+	resumed chan string
+}
+
+func (b *failingBackend) Topic(id string) Topic {
+	return &failingTopic{Topic: b.Backend.Topic(id), resumed: b.resumed}
+}
+
+type failingTopic struct {
+	Topic
+	failed  bool
+	resumed chan string
+}
+
+func (t *failingTopic) Publish(ctx context.Context, m *pubsub.Message) PublishResult {
+	if !t.failed {
+		t.failed = true
+		return resolvedPublishResult("", errors.New("unavailable"))
+	}
+
+	return t.Topic.Publish(ctx, m)
+}
+
+func (t *failingTopic) ResumePublish(key string) {
+	t.resumed <- key
+}
+
+func TestPubSub_PublishWithOrderingKey_Resume(t *testing.T) {
+	b := &failingBackend{NewMemoryBackend(), make(chan string, 1)}
+	ps, err := NewPubSub(context.Background(), Options("test-project").SetBackend(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PublishWithOrderingKey("topic", "a", "hello"); err == nil {
+		t.Fatal("PublishWithOrderingKey() error = nil, want the publish error")
+	}
+
+	select {
+	case k := <-b.resumed:
+		if k != "a" {
+			t.Errorf("ResumePublish() key = %s, want a", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the ordering key was not resumed")
+	}
+
+	if err := ps.PublishWithOrderingKey("topic", "a", "hello"); err != nil {
+		t.Errorf("PublishWithOrderingKey() error = %v", err)
+	}
+}
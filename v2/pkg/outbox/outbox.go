@@ -0,0 +1,120 @@
+// Package outbox implements the transactional outbox pattern on top of
+// database/sql. Messages are written to an outbox table in the same
+// transaction as the changes they describe, and a Relay publishes them once
+// the transaction has committed, so that a message is never lost when
+// publishing fails after the commit.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	pb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+// Dialect holds the SQL that differs between databases.
+type Dialect = pb.SQLDialect
+
+var (
+	// MySQL is the Dialect of MySQL and MariaDB.
+	MySQL = pb.MySQLDialect
+	// Postgres is the Dialect of PostgreSQL.
+	Postgres = pb.PostgresDialect
+	// SQLite is the Dialect of SQLite.
+	SQLite = pb.SQLiteDialect
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Outbox is an outbox table that messages are written to and relayed from.
+type Outbox struct {
+	dialect Dialect
+	table   string
+}
+
+// New returns an Outbox that keeps messages in the table.
+func New(table string, d Dialect) (*Outbox, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+
+	return &Outbox{
+		dialect: d,
+		table:   table,
+	}, nil
+}
+
+// CreateTable creates the outbox table if it does not exist, with an index on
+// sent_at to find the unsent and expired messages.
+func (o *Outbox) CreateTable(ctx context.Context, db *sql.DB) error {
+	idx := fmt.Sprintf("%s_sent_at", o.table)
+
+	var inline string
+	if o.dialect.InlineIndex {
+		inline = fmt.Sprintf(",\n\tINDEX %s (sent_at, id)", idx)
+	}
+
+	q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	topic VARCHAR(255) NOT NULL,
+	payload %s NOT NULL,
+	attributes TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	claimed_by VARCHAR(64),
+	claimed_until BIGINT NOT NULL DEFAULT 0,
+	sent_at BIGINT%s
+)`, o.table, o.dialect.AutoIncrement, o.dialect.Blob, inline)
+
+	if _, err := db.ExecContext(ctx, q); err != nil {
+		return err
+	}
+	if o.dialect.InlineIndex {
+		return nil
+	}
+
+	q = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (sent_at, id)", idx, o.table)
+	_, err := db.ExecContext(ctx, q)
+
+	return err
+}
+
+// Purge deletes the messages that were sent before the time, returning the
+// number of messages deleted.
+func (o *Outbox) Purge(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	q := fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s", o.table, o.ph(1))
+	res, err := db.ExecContext(ctx, q, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Write inserts a message for the topic into the outbox within the caller's
+// transaction, so that it is only relayed if the transaction commits.
+func (o *Outbox) Write(ctx context.Context, tx *sql.Tx, topic string, payload []byte, attrs map[string]string) error {
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+
+	a, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+
+	q := fmt.Sprintf("INSERT INTO %s (topic, payload, attributes, created_at) VALUES (%s, %s, %s, %s)",
+		o.table, o.ph(1), o.ph(2), o.ph(3), o.ph(4))
+	_, err = tx.ExecContext(ctx, q, topic, payload, string(a), time.Now().UnixNano())
+
+	return err
+}
+
+func (o *Outbox) ph(n int) string {
+	return o.dialect.Placeholder(n)
+}
@@ -0,0 +1,351 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+	_ "modernc.org/sqlite"
+)
+
+// testOutbox is the outbox that the tests write messages to.
+var testOutbox, _ = New("pubsub_outbox", SQLite)
+
+// newTestOutbox creates testOutbox in a new SQLite database, and returns the
+// database with a memory PubSub with an orders topic and subscription.
+func newTestOutbox(t *testing.T) (*sql.DB, *pb.PubSub) {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// SQLite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	if err := testOutbox.CreateTable(ctx, db); err != nil {
+		t.Fatalf("CreateTable() error = %v", err)
+	}
+
+	ps, err := pb.NewPubSub(ctx, pb.Options("test-project").SetBackend(pb.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+
+	if err := ps.CreateTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("orders", "orders-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, ps
+}
+
+// write writes the messages to the outbox in a transaction that is committed
+// or rolled back.
+func write(t *testing.T, db *sql.DB, commit bool, topic string, payloads ...string) {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range payloads {
+		if err := testOutbox.Write(ctx, tx, topic, []byte(p), map[string]string{"source": "outbox"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if !commit {
+		tx.Rollback()
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive returns the data of the n messages in the subscription in the order
+// they were published.
+func receive(t *testing.T, ps *pb.PubSub, sid string, n int) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var msgs []*pb.Message
+	err := ps.ReceiveFunc(ctx, sid, func(ctx context.Context, m *pb.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if m.Attributes["source"] != "outbox" {
+			t.Errorf("message attributes = %v, want the written attributes", m.Attributes)
+		}
+
+		msgs = append(msgs, m)
+		if len(msgs) == n {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReceiveFunc() error = %v", err)
+	}
+
+	// the memory backend numbers messages as they are published
+	sort.Slice(msgs, func(i, j int) bool {
+		a, _ := strconv.Atoi(msgs[i].ID)
+		b, _ := strconv.Atoi(msgs[j].ID)
+		return a < b
+	})
+
+	var got []string
+	for _, m := range msgs {
+		got = append(got, string(m.Data))
+	}
+
+	return got
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	db, ps := newTestOutbox(t)
+	ctx := context.Background()
+
+	write(t, db, false, "orders", "rolled back")
+	write(t, db, true, "orders", "first", "second")
+
+	r := testOutbox.NewRelay(db, ps)
+	if n, err := r.RelayOnce(ctx); err != nil || n != 2 {
+		t.Fatalf("RelayOnce() = %d, %v, want 2 messages", n, err)
+	}
+	if n, err := r.RelayOnce(ctx); err != nil || n != 0 {
+		t.Errorf("RelayOnce() = %d, %v, want the messages to have been marked as sent", n, err)
+	}
+
+	got := receive(t, ps, "orders-sub", 2)
+	if got[0] != "first" || got[1] != "second" {
+		t.Errorf("relayed %v, want first and second", got)
+	}
+}
+
+func TestRelay_Retry(t *testing.T) {
+	db, ps := newTestOutbox(t)
+	ctx := context.Background()
+
+	// the invoices topic does not exist yet
+	write(t, db, true, "invoices", "invoice 1")
+	write(t, db, true, "orders", "order 1")
+	write(t, db, true, "invoices", "invoice 2")
+
+	r := testOutbox.NewRelay(db, ps)
+	r.MinBackoff = 10 * time.Millisecond
+	if _, err := r.RelayOnce(ctx); err != nil {
+		t.Fatalf("RelayOnce() error = %v", err)
+	}
+
+	var attempts int
+	var lastErr sql.NullString
+	if err := db.QueryRow("SELECT attempts, last_error FROM pubsub_outbox WHERE id = 1").Scan(&attempts, &lastErr); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || !lastErr.Valid {
+		t.Errorf("failed message has %d attempts and error %q, want 1 attempt and the error", attempts, lastErr.String)
+	}
+
+	// the later invoice should be held back until the first is retried
+	if n, _ := r.RelayOnce(ctx); n != 0 {
+		t.Errorf("RelayOnce() = %d, want the invoices to be held back", n)
+	}
+
+	if err := ps.CreateTopic("invoices"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("invoices", "invoices-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n, err := r.RelayOnce(ctx); err != nil || n != 2 {
+		t.Fatalf("RelayOnce() = %d, %v, want the invoices to be retried", n, err)
+	}
+
+	got := receive(t, ps, "invoices-sub", 2)
+	if got[0] != "invoice 1" || got[1] != "invoice 2" {
+		t.Errorf("relayed %v, want the invoices in the order they were written", got)
+	}
+}
+
+func TestRelay_RetryAcrossBatches(t *testing.T) {
+	db, ps := newTestOutbox(t)
+	ctx := context.Background()
+
+	// the invoices topic does not exist yet, and there are more invoices
+	// waiting than fit in a batch
+	write(t, db, true, "invoices", "invoice 1", "invoice 2", "invoice 3", "invoice 4", "invoice 5")
+
+	r := testOutbox.NewRelay(db, ps)
+	r.BatchSize = 2
+	r.MinBackoff = 50 * time.Millisecond
+	if _, err := r.RelayOnce(ctx); err != nil {
+		t.Fatalf("RelayOnce() error = %v", err)
+	}
+
+	if err := ps.CreateTopic("invoices"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("invoices", "invoices-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the invoices in the next batches should wait for the first to be retried
+	if n, err := r.RelayOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("RelayOnce() = %d, %v, want the later invoices to be held back", n, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := r.RelayOnce(ctx); err != nil {
+			t.Fatalf("RelayOnce() error = %v", err)
+		}
+	}
+
+	got := receive(t, ps, "invoices-sub", 5)
+	want := []string{"invoice 1", "invoice 2", "invoice 3", "invoice 4", "invoice 5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("relayed %v, want the invoices in the order they were written", got)
+	}
+}
+
+func TestRelay_MultipleInstances(t *testing.T) {
+	db, ps := newTestOutbox(t)
+
+	var payloads []string
+	for i := 0; i < 30; i++ {
+		payloads = append(payloads, strconv.Itoa(i))
+	}
+	write(t, db, true, "orders", payloads...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		r := testOutbox.NewRelay(db, ps)
+		r.BatchSize = 4
+		r.Interval = 10 * time.Millisecond
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.Run(ctx); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		}()
+	}
+
+	got := receive(t, ps, "orders-sub", len(payloads))
+
+	// wait for the last messages to be marked as sent
+	var unsent int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err := db.QueryRow("SELECT COUNT(*) FROM pubsub_outbox WHERE sent_at IS NULL").Scan(&unsent); err != nil {
+			t.Fatal(err)
+		}
+		if unsent == 0 {
+			break
+		}
+	}
+	if unsent != 0 {
+		t.Errorf("%d messages were not marked as sent", unsent)
+	}
+
+	cancel()
+	wg.Wait()
+
+	// every message should be published exactly once
+	sort.Strings(got)
+	sort.Strings(payloads)
+	if len(got) != len(payloads) {
+		t.Fatalf("relayed %d messages, want %d", len(got), len(payloads))
+	}
+	for i := range got {
+		if got[i] != payloads[i] {
+			t.Fatalf("relayed %v, want %v", got, payloads)
+		}
+	}
+}
+
+func TestRelay_LeaseLost(t *testing.T) {
+	db, ps := newTestOutbox(t)
+	ctx := context.Background()
+
+	write(t, db, true, "orders", "first")
+
+	r := testOutbox.NewRelay(db, ps)
+	r.defaults()
+	rows, err := r.claim(ctx)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("claim() = %d rows, %v, want 1 row", len(rows), err)
+	}
+
+	// another relay claims the message once the lease has expired
+	if _, err := db.Exec("UPDATE pubsub_outbox SET claimed_by = 'other'"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.sent(ctx, rows[0]); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("sent() error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestOutbox_Purge(t *testing.T) {
+	db, ps := newTestOutbox(t)
+	ctx := context.Background()
+
+	write(t, db, true, "orders", "first", "second")
+
+	r := testOutbox.NewRelay(db, ps)
+	if _, err := r.RelayOnce(ctx); err != nil {
+		t.Fatalf("RelayOnce() error = %v", err)
+	}
+	write(t, db, true, "orders", "unsent")
+
+	if n, err := testOutbox.Purge(ctx, db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Purge() = %d, %v, want no messages sent an hour ago", n, err)
+	}
+	if n, err := testOutbox.Purge(ctx, db, time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("Purge() = %d, %v, want the 2 sent messages", n, err)
+	}
+
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pubsub_outbox WHERE sent_at IS NULL").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d unsent messages are left, want 1", left)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("outbox; DROP TABLE orders", Postgres); err == nil {
+		t.Error("New() error = nil, want an invalid table name error")
+	}
+	if _, err := New("events_outbox", Postgres); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	pb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+)
+
+const (
+	defaultBatchSize  = 100
+	defaultInterval   = time.Second
+	defaultLease      = 30 * time.Second
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// ErrLeaseLost is returned by RelayOnce for messages that were published but
+// whose lease expired, and were claimed by another relay, before they could be
+// marked as sent, so that they may be published again.
+var ErrLeaseLost = errors.New("lease of outbox message was lost")
+
+// Relay publishes the messages written to an Outbox and marks them as sent.
+// Several relays, in the same or different processes, can relay the same
+// outbox: each message is claimed by one relay for the Lease before it is
+// published, and is claimed again by any relay if it has not been marked as
+// sent by then.
+//
+// The messages of a topic are published in the order they were written: a
+// message is not claimed while an earlier message of its topic is claimed by
+// another relay or waiting to be retried, and a relay stops publishing the
+// messages of a topic at the first failure. Messages that fail are retried after a
+// backoff that doubles from MinBackoff up to MaxBackoff. Messages are
+// published at least once: a message that was published but could not be
+// marked as sent, such as when the relay stops, is published again. Zero values
+// are replaced by the defaults when the relay runs.
+type Relay struct {
+	// BatchSize is the maximum number of messages claimed at once (100 by
+	// default).
+	BatchSize int
+	// ID identifies the relay in the claimed_by column (random by default).
+	ID string
+	// Interval is how long to wait before polling again once the outbox is
+	// empty (1 second by default).
+	Interval time.Duration
+	// Lease is how long a claimed message is reserved for the relay (30
+	// seconds by default). It should be longer than publishing a batch takes.
+	Lease time.Duration
+	// Logger logs the messages whose lease was lost while the relay runs
+	// (none by default).
+	Logger *slog.Logger
+	// MaxAttempts is the number of times a message is attempted before it is
+	// left in the outbox unsent, or 0 to retry it forever.
+	MaxAttempts int
+	// MaxBackoff is the longest delay before a failed message is retried (1
+	// minute by default).
+	MaxBackoff time.Duration
+	// MinBackoff is the delay before a failed message is first retried (1
+	// second by default).
+	MinBackoff time.Duration
+	// Retention is how long sent messages are kept before Run deletes them
+	// while the outbox is empty, or 0 to keep them.
+	Retention time.Duration
+
+	db *sql.DB
+	o  *Outbox
+	ps *pb.PubSub
+}
+
+type row struct {
+	attempts int
+	attrs    map[string]string
+	id       int64
+	payload  []byte
+	topic    string
+}
+
+// NewRelay returns a Relay that publishes the messages of the outbox in the
+// database with the PubSub.
+func (o *Outbox) NewRelay(db *sql.DB, ps *pb.PubSub) *Relay {
+	return &Relay{
+		db: db,
+		o:  o,
+		ps: ps,
+	}
+}
+
+// Run relays messages until the context is done. Errors reading or updating
+// the outbox stop the relay, while errors publishing messages are retried.
+func (r *Relay) Run(ctx context.Context) error {
+	r.defaults()
+
+	for {
+		n, err := r.RelayOnce(ctx)
+		if errors.Is(err, ErrLeaseLost) {
+			if r.Logger != nil {
+				r.Logger.WarnContext(ctx, "outbox message may be published again", slog.String("relay", r.ID), slog.Any("error", err))
+			}
+			err = nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// keep going while there are messages waiting
+		if n > 0 {
+			continue
+		}
+
+		if r.Retention > 0 {
+			if _, err := r.o.Purge(ctx, r.db, time.Now().Add(-r.Retention)); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// RelayOnce claims a batch of messages and publishes them, returning the
+// number of messages that were claimed. Messages whose lease was lost are
+// reported with ErrLeaseLost once the rest of the batch has been relayed.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	r.defaults()
+
+	rows, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// the time the first failed message of each topic is retried
+	retries := make(map[string]time.Time)
+	var lost []error
+	for _, rw := range rows {
+		// hold back the later messages of the topic until then, so that they
+		// are not published before it
+		if retry, ok := retries[rw.topic]; ok {
+			if err := r.release(ctx, rw, retry); err != nil {
+				return len(rows), err
+			}
+			continue
+		}
+
+		if err := r.ps.Publish(rw.topic, rw.payload, rw.attrs); err != nil {
+			retry := time.Now().Add(r.backoff(rw.attempts + 1))
+			retries[rw.topic] = retry
+			if err := r.fail(ctx, rw, retry, err); err != nil {
+				return len(rows), err
+			}
+			continue
+		}
+
+		if err := r.sent(ctx, rw); errors.Is(err, ErrLeaseLost) {
+			lost = append(lost, err)
+		} else if err != nil {
+			return len(rows), err
+		}
+	}
+
+	return len(rows), errors.Join(lost...)
+}
+
+func (r *Relay) defaults() {
+	if r.BatchSize <= 0 {
+		r.BatchSize = defaultBatchSize
+	}
+	if r.ID == "" {
+		r.ID = relayID()
+	}
+	if r.Interval <= 0 {
+		r.Interval = defaultInterval
+	}
+	if r.Lease <= 0 {
+		r.Lease = defaultLease
+	}
+	if r.MinBackoff <= 0 {
+		r.MinBackoff = defaultMinBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultMaxBackoff
+	}
+}
+
+// relayID returns a random ID, or the host name and process ID when no random
+// bytes can be read.
+func relayID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err == nil {
+		return hex.EncodeToString(b)
+	}
+
+	h, err := os.Hostname()
+	if err != nil {
+		h = "relay"
+	}
+
+	return fmt.Sprintf("%s-%d", h, os.Getpid())
+}
+
+// claim reserves the oldest unsent messages that are not claimed by another
+// relay. Messages are skipped while an earlier message of their topic is
+// claimed or waiting to be retried, so that they are never published before
+// it. Candidates are selected first and then claimed one by one, only if they
+// are still available, so that relays never claim the same message; once a
+// message of a topic is lost to another relay, the later messages of the topic
+// are left to it.
+func (r *Relay) claim(ctx context.Context) ([]row, error) {
+	o := r.o
+	now := time.Now().UnixNano()
+
+	q := fmt.Sprintf(`SELECT id, topic, payload, attributes, attempts FROM %[1]s t WHERE sent_at IS NULL AND claimed_until < %[2]s
+	AND NOT EXISTS (SELECT 1 FROM %[1]s e WHERE e.topic = t.topic AND e.sent_at IS NULL AND e.id < t.id AND e.claimed_until >= %[3]s)`,
+		o.table, o.ph(1), o.ph(2))
+	args := []any{now, now}
+	if r.MaxAttempts > 0 {
+		q += fmt.Sprintf(" AND attempts < %s", o.ph(3))
+		args = append(args, r.MaxAttempts)
+	}
+	q += fmt.Sprintf(" ORDER BY id LIMIT %d", r.BatchSize)
+
+	rs, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var cands []row
+	for rs.Next() {
+		var rw row
+		var attrs string
+		if err := rs.Scan(&rw.id, &rw.topic, &rw.payload, &attrs, &rw.attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(attrs), &rw.attrs); err != nil {
+			return nil, fmt.Errorf("invalid attributes of outbox message %d: %w", rw.id, err)
+		}
+
+		cands = append(cands, rw)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, err
+	}
+	rs.Close()
+
+	q = fmt.Sprintf("UPDATE %s SET claimed_by = %s, claimed_until = %s WHERE id = %s AND sent_at IS NULL AND claimed_until < %s",
+		o.table, o.ph(1), o.ph(2), o.ph(3), o.ph(4))
+	until := time.Now().Add(r.Lease).UnixNano()
+
+	var claimed []row
+	lost := make(map[string]bool)
+	for _, rw := range cands {
+		if lost[rw.topic] {
+			continue
+		}
+
+		res, err := r.db.ExecContext(ctx, q, r.ID, until, rw.id, now)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n != 1 {
+			lost[rw.topic] = true
+			continue
+		}
+
+		claimed = append(claimed, rw)
+	}
+
+	return claimed, nil
+}
+
+// sent marks a claimed message as sent, or returns ErrLeaseLost if it is no
+// longer claimed by the relay.
+func (r *Relay) sent(ctx context.Context, rw row) error {
+	o := r.o
+	q := fmt.Sprintf("UPDATE %s SET sent_at = %s, attempts = attempts + 1, last_error = NULL WHERE id = %s AND claimed_by = %s AND sent_at IS NULL",
+		o.table, o.ph(1), o.ph(2), o.ph(3))
+	res, err := r.db.ExecContext(ctx, q, time.Now().UnixNano(), rw.id, r.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("%w: message %d", ErrLeaseLost, rw.id)
+	}
+
+	return nil
+}
+
+// fail records the error of a message that could not be published and gives
+// up the claim on it until the retry time.
+func (r *Relay) fail(ctx context.Context, rw row, retry time.Time, perr error) error {
+	o := r.o
+	q := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s, claimed_by = NULL, claimed_until = %s WHERE id = %s AND claimed_by = %s",
+		o.table, o.ph(1), o.ph(2), o.ph(3), o.ph(4))
+	_, err := r.db.ExecContext(ctx, q, perr.Error(), retry.UnixNano(), rw.id, r.ID)
+
+	return err
+}
+
+// release gives up the claim on a message that was not attempted until the
+// retry time.
+func (r *Relay) release(ctx context.Context, rw row, retry time.Time) error {
+	o := r.o
+	q := fmt.Sprintf("UPDATE %s SET claimed_by = NULL, claimed_until = %s WHERE id = %s AND claimed_by = %s",
+		o.table, o.ph(1), o.ph(2), o.ph(3))
+	_, err := r.db.ExecContext(ctx, q, retry.UnixNano(), rw.id, r.ID)
+
+	return err
+}
+
+// backoff returns the delay before a message that has been attempted the
+// number of times is retried.
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.MinBackoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+
+	return d
+}
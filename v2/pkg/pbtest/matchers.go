@@ -0,0 +1,61 @@
+package pbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Matcher reports whether a published message is the one that is expected.
+type Matcher func(Published) bool
+
+// Any matches every message.
+func Any() Matcher {
+	return func(Published) bool { return true }
+}
+
+// All matches messages that match every one of the provided matchers.
+func All(ms ...Matcher) Matcher {
+	return func(p Published) bool {
+		for _, m := range ms {
+			if !m(p) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// DataEquals matches messages whose data is exactly the provided bytes.
+func DataEquals(d []byte) Matcher {
+	return func(p Published) bool {
+		return bytes.Equal(p.Data, d)
+	}
+}
+
+// JSONEquals matches messages whose data is JSON equivalent to the provided
+// value once marshalled, ignoring formatting and key order.
+func JSONEquals(v any) Matcher {
+	return func(p Published) bool {
+		want, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+
+		var got, exp any
+		if json.Unmarshal(p.Data, &got) != nil || json.Unmarshal(want, &exp) != nil {
+			return false
+		}
+
+		return reflect.DeepEqual(got, exp)
+	}
+}
+
+// HasAttribute matches messages with the attribute set to the provided value.
+func HasAttribute(k string, v string) Matcher {
+	return func(p Published) bool {
+		av, ok := p.Attributes[k]
+		return ok && av == v
+	}
+}
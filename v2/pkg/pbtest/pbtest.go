@@ -0,0 +1,217 @@
+// Package pbtest provides a test harness that runs a pb.PubSub against an
+// in-process fake Pub/Sub server, along with assertions for the messages that
+// were published and acknowledged.
+package pbtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pubsubpb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	pb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultTimeout is how long ExpectPublished and WaitForAck wait before
+// failing the test.
+const DefaultTimeout = 5 * time.Second
+
+// Published is a message that was published to the fake server.
+type Published struct {
+	Attributes  map[string]string
+	Data        []byte
+	OrderingKey string
+	Topic       string
+}
+
+// Harness is a ready to use pb.PubSub connected to a fake Pub/Sub server. The
+// fake server and client are closed when the test completes.
+type Harness struct {
+	*pb.PubSub
+
+	Server  *pstest.Server
+	Timeout time.Duration
+
+	acks      map[string]int
+	consumed  map[string]int
+	mu        sync.Mutex
+	published []Published
+	t         testing.TB
+}
+
+// New returns a Harness for the test using the project ID "test-project".
+func New(t testing.TB) *Harness {
+	return NewWithOptions(t, pb.Options("test-project"))
+}
+
+// NewWithOptions returns a Harness for the test using the provided options.
+// A copy of the options is connected to the fake server, so any Backend that
+// is set is ignored and the caller's options are left unchanged.
+func NewWithOptions(t testing.TB, opts *pb.PubSubOptions) *Harness {
+	t.Helper()
+
+	o := *opts
+	o.ClientOptions = append([]option.ClientOption{}, opts.ClientOptions...)
+
+	h := &Harness{
+		Timeout:  DefaultTimeout,
+		acks:     make(map[string]int),
+		consumed: make(map[string]int),
+		t:        t,
+	}
+
+	// record published and acknowledged messages as they reach the server
+	h.Server = pstest.NewServer(
+		pstest.ServerReactorOption{FuncName: "Publish", Reactor: reactor(h.onPublish)},
+		pstest.ServerReactorOption{FuncName: "Acknowledge", Reactor: reactor(h.onAcknowledge)},
+	)
+	t.Cleanup(func() { h.Server.Close() })
+
+	conn, err := grpc.NewClient(h.Server.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("pbtest: unable to connect to fake server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ps, err := pb.NewPubSub(ctx, o.SetBackend(nil).SetClientOptions(option.WithGRPCConn(conn)))
+	if err != nil {
+		cancel()
+		t.Fatalf("pbtest: unable to create PubSub: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		ps.Close()
+	})
+
+	h.PubSub = ps
+
+	return h
+}
+
+// Published returns the messages that have been published to the topic.
+func (h *Harness) Published(topic string) []Published {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var pbd []Published
+	for _, p := range h.published {
+		if p.Topic == topic {
+			pbd = append(pbd, p)
+		}
+	}
+
+	return pbd
+}
+
+// ExpectPublished waits for a message matching the matcher to be published to
+// the topic and returns it, failing the test if none is published before the
+// timeout.
+func (h *Harness) ExpectPublished(topic string, m Matcher) Published {
+	h.t.Helper()
+
+	var found Published
+	ok := h.poll(func() bool {
+		for _, p := range h.Published(topic) {
+			if m(p) {
+				found = p
+				return true
+			}
+		}
+
+		return false
+	})
+	if !ok {
+		h.t.Fatalf("pbtest: no matching message was published to topic %s within %v", topic, h.Timeout)
+	}
+
+	return found
+}
+
+// WaitForAck waits for the next message to be acknowledged on the
+// subscription, failing the test if none is acknowledged before the timeout.
+// Each call consumes one acknowledgement, so calling it n times waits for n
+// acknowledgements in total.
+func (h *Harness) WaitForAck(subID string) {
+	h.t.Helper()
+
+	ok := h.poll(func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.acks[subID] > h.consumed[subID] {
+			h.consumed[subID]++
+			return true
+		}
+
+		return false
+	})
+	if !ok {
+		h.t.Fatalf("pbtest: no message was acknowledged on subscription %s within %v", subID, h.Timeout)
+	}
+}
+
+// poll calls f until it returns true or the timeout elapses.
+func (h *Harness) poll(f func() bool) bool {
+	deadline := time.Now().Add(h.Timeout)
+	for {
+		if f() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (h *Harness) onPublish(req any) {
+	pr, ok := req.(*pubsubpb.PublishRequest)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, m := range pr.Messages {
+		h.published = append(h.published, Published{
+			Attributes:  m.Attributes,
+			Data:        m.Data,
+			OrderingKey: m.OrderingKey,
+			Topic:       resourceID(pr.Topic),
+		})
+	}
+}
+
+func (h *Harness) onAcknowledge(req any) {
+	ar, ok := req.(*pubsubpb.AcknowledgeRequest)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.acks[resourceID(ar.Subscription)] += len(ar.AckIds)
+}
+
+// reactor observes requests made to the fake server without handling them.
+type reactor func(req any)
+
+func (r reactor) React(req interface{}) (bool, interface{}, error) {
+	r(req)
+	return false, nil, nil
+}
+
+// resourceID returns the final segment of a resource name such as
+// projects/<project>/topics/<topic>.
+func resourceID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
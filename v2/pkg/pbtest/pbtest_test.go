@@ -0,0 +1,83 @@
+package pbtest_test
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/clearchanneloutdoor/pubsub-go/v2/pkg"
+	"github.com/clearchanneloutdoor/pubsub-go/v2/pkg/pbtest"
+)
+
+func TestHarness(t *testing.T) {
+	h := pbtest.New(t)
+	if err := h.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Publish("topic", map[string]any{"message": "hello world"}, map[string]string{"region": "CA"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := h.ExpectPublished("topic", pbtest.All(
+		pbtest.JSONEquals(map[string]any{"message": "hello world"}),
+		pbtest.HasAttribute("region", "CA"),
+	))
+	if p.Topic != "topic" {
+		t.Errorf("ExpectPublished() topic = %s, want topic", p.Topic)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.ReceiveFunc(ctx, "sub", func(ctx context.Context, m *pb.Message) error {
+		return nil
+	})
+
+	h.WaitForAck("sub")
+}
+
+func TestNewWithOptions(t *testing.T) {
+	opts := pb.Options("test-project")
+
+	// the options should not be changed, so that they can be shared
+	for i := 0; i < 2; i++ {
+		h := pbtest.NewWithOptions(t, opts)
+		if err := h.CreateTopic("topic"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(opts.ClientOptions) != 0 {
+		t.Errorf("NewWithOptions() added %d client options, want the options to be unchanged", len(opts.ClientOptions))
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	p := pbtest.Published{
+		Attributes: map[string]string{"region": "CA"},
+		Data:       []byte(`{"b":2,"a":1}`),
+	}
+
+	tests := []struct {
+		name string
+		m    pbtest.Matcher
+		want bool
+	}{
+		{"Any should match", pbtest.Any(), true},
+		{"DataEquals should match equal data", pbtest.DataEquals([]byte(`{"b":2,"a":1}`)), true},
+		{"DataEquals should not match different data", pbtest.DataEquals([]byte(`{"a":1,"b":2}`)), false},
+		{"JSONEquals should ignore key order", pbtest.JSONEquals(map[string]int{"a": 1, "b": 2}), true},
+		{"JSONEquals should not match different values", pbtest.JSONEquals(map[string]int{"a": 2}), false},
+		{"HasAttribute should match the attribute", pbtest.HasAttribute("region", "CA"), true},
+		{"HasAttribute should not match a different value", pbtest.HasAttribute("region", "NV"), false},
+		{"All should require every matcher", pbtest.All(pbtest.Any(), pbtest.HasAttribute("region", "NV")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m(p); got != tt.want {
+				t.Errorf("Matcher() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
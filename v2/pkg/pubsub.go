@@ -2,26 +2,37 @@ package pb
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 )
 
-type gcpPubSubProvider interface {
-	Close() error
-	CreateSubscription(context.Context, string, pubsub.SubscriptionConfig) (*pubsub.Subscription, error)
-	CreateTopic(context.Context, string) (*pubsub.Topic, error)
-	CreateTopicWithConfig(context.Context, string, *pubsub.TopicConfig) (*pubsub.Topic, error)
-	Subscription(string) *pubsub.Subscription
-	Topic(string) *pubsub.Topic
+// ErrRawReceiveUnsupported is returned by Receive when the Backend cannot
+// provide the underlying pubsub.Message values. Use ReceiveFunc instead.
+var ErrRawReceiveUnsupported = errors.New("backend does not support receiving *pubsub.Message values, use ReceiveFunc instead")
+
+// rawReceiver is implemented by subscriptions that can deliver the underlying
+// pubsub.Message values.
+type rawReceiver interface {
+	receiveRaw(context.Context, pubsub.ReceiveSettings, func(context.Context, *pubsub.Message)) error
 }
 
+// defaultShutdownTimeout is how long Close waits for messages to be published
+// when PubSubOptions.ShutdownTimeout is not set.
+const defaultShutdownTimeout = 10 * time.Second
+
 type PubSub struct {
-	clnt gcpPubSubProvider
-	ctx  context.Context
-	opts *PubSubOptions
+	clnt       Backend
+	ctx        context.Context
+	mu         sync.RWMutex
+	opts       *PubSubOptions
+	topics     map[string]Topic
+	tmu        sync.Mutex
+	validators map[string]SchemaValidator
 }
 
 func (p *PubSub) ensurePublishSettings() {
@@ -36,10 +47,74 @@ func (p *PubSub) ensureReceiveSettings() {
 	}
 }
 
+// Backend returns the Backend that PubSub manages topics and subscriptions on,
+// for operations that PubSub does not provide itself.
+func (p *PubSub) Backend() Backend {
+	return p.clnt
+}
+
+// Close stops the topics that messages have been published to, waiting up to
+// the ShutdownTimeout set in PubSubOptions for pending messages to be sent, and
+// then closes the Backend. Messages that have not been sent by then fail.
 func (p *PubSub) Close() error {
-	return p.clnt.Close()
+	p.log(p.ctx, slog.LevelInfo, "closing client")
+
+	err := p.stopTopics()
+	if err != nil {
+		p.log(p.ctx, slog.LevelWarn, "failed to publish pending messages", slog.Any("error", err))
+	}
+
+	if cerr := p.clnt.Close(); cerr != nil {
+		p.log(p.ctx, slog.LevelError, "failed to close client", slog.Any("error", cerr))
+		return cerr
+	}
+
+	p.log(p.ctx, slog.LevelInfo, "closed client")
+
+	return err
+}
+
+// stopTopics stops every cached topic concurrently, which flushes the messages
+// they have bundled, and gives up once the shutdown timeout has passed.
+func (p *PubSub) stopTopics() error {
+	p.tmu.Lock()
+	ts := p.topics
+	p.topics = nil
+	p.tmu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range ts {
+		wg.Add(1)
+		go func(t Topic) {
+			defer wg.Done()
+			t.Stop()
+		}(t)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	d := p.opts.ShutdownTimeout
+	if d == 0 {
+		d = defaultShutdownTimeout
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(d):
+		return fmt.Errorf("timed out after %v waiting for messages to be published", d)
+	}
 }
 
+// CreateSubscription creates a subscription to the topic with the provided
+// filter if it does not already exist. When it does exist and a DriftMode other
+// than DriftIgnore is set in PubSubOptions, its topic, filter, ack deadline,
+// dead letter policy and retry policy are compared with the ones requested, and
+// any difference is handled according to the mode.
 func (p *PubSub) CreateSubscription(id string, sid string, fltr string, cfg ...pubsub.SubscriptionConfig) error {
 	// ensure we have a subscription config
 	ss := pubsub.SubscriptionConfig{}
@@ -47,9 +122,10 @@ func (p *PubSub) CreateSubscription(id string, sid string, fltr string, cfg ...p
 		ss = cfg[0]
 	}
 
-	// set topic for the subscription
-	t := p.clnt.Topic(id)
-	ss.Topic = t
+	// set the filter if provided
+	if fltr != "" {
+		ss.Filter = fltr
+	}
 
 	// check to see if the requested subscription already exists
 	exists, err := p.clnt.Subscription(sid).Exists(p.ctx)
@@ -57,19 +133,22 @@ func (p *PubSub) CreateSubscription(id string, sid string, fltr string, cfg ...p
 		return err
 	}
 
-	// create the subscription if it does not exist
-	if !exists {
-		// set the filter if provided
-		if fltr != "" {
-			ss.Filter = fltr
-		}
-
-		// create the subscription
-		if _, err := p.clnt.CreateSubscription(p.ctx, sid, ss); err != nil {
+	// ensure an existing subscription matches the requested configuration
+	if exists {
+		if err := p.checkDrift(id, sid, ss); err != nil {
 			return err
 		}
+
+		p.log(p.ctx, slog.LevelInfo, "subscription already exists, skipped creating it", slog.String("topic", id), slog.String("subscription", sid))
+		return nil
+	}
+
+	// create the subscription
+	if err := p.clnt.CreateSubscription(p.ctx, sid, id, ss); err != nil {
+		return err
 	}
 
+	p.log(p.ctx, slog.LevelInfo, "created subscription", slog.String("topic", id), slog.String("subscription", sid))
 	return nil
 }
 
@@ -91,96 +170,280 @@ func (p *PubSub) CreateTopic(id string, cfg ...pubsub.TopicConfig) error {
 		return err
 	}
 
-	// create the topic with or without configuration
-	ct := func() error {
-		if len(cfg) > 0 {
-			if _, err := p.clnt.CreateTopicWithConfig(p.ctx, id, &cfg[0]); err != nil {
-				return err
-			}
-		}
-
-		if _, err := p.clnt.CreateTopic(p.ctx, id); err != nil {
-			return err
-		}
-
-		return nil
+	// use the configuration if provided
+	var tc *pubsub.TopicConfig
+	if len(cfg) > 0 {
+		tc = &cfg[0]
 	}
 
 	// create the topic if it does not exist
 	if !exists {
-		if err := ct(); err != nil {
+		if err := p.clnt.CreateTopic(p.ctx, id, tc); err != nil {
 			return err
 		}
+
+		p.log(p.ctx, slog.LevelInfo, "created topic", slog.String("topic", id))
+		return nil
 	}
 
 	// topic exists
+	p.log(p.ctx, slog.LevelInfo, "topic already exists, skipped creating it", slog.String("topic", id))
 	return nil
 }
 
 func (p *PubSub) Publish(id string, d any, attrs ...map[string]string) error {
-	t := p.clnt.Topic(id)
+	return p.publish(id, nil, d, attrs...)
+}
 
-	// apply PublishSettings
-	p.ensurePublishSettings()
-	t.PublishSettings = p.opts.PublishSettings
+// PublishContext publishes the data to the topic in the same way as Publish,
+// using the context, such as to publish the message within the span in it.
+func (p *PubSub) PublishContext(ctx context.Context, id string, d any, attrs ...map[string]string) error {
+	_, err := p.publishAsync(ctx, p.topic(id), Msg{Attributes: mergeMaps(attrs...), Data: d}).Get(ctx)
+	return err
+}
 
-	// marshal provided data as JSON if needed
-	var dta []byte
-	if _, ok := d.([]byte); !ok {
-		data, err := json.Marshal(d)
-		if err != nil {
-			return err
-		}
+// PublishWithCodec publishes the data to the topic after marshalling it with
+// the provided codec, rather than the codec set in PubSubOptions. Unlike
+// Publish, []byte values are also passed to the codec.
+func (p *PubSub) PublishWithCodec(id string, c Codec, d any, attrs ...map[string]string) error {
+	return p.publish(id, c, d, attrs...)
+}
 
-		dta = data
-	}
+// PublishAsync publishes the data to the topic in the same way as Publish, but
+// returns without waiting for the message to be sent. The PublishResult
+// returns the server-generated message ID, or the error that prevented the
+// message from being published.
+func (p *PubSub) PublishAsync(id string, d any, attrs ...map[string]string) PublishResult {
+	return p.publishAsync(p.ctx, p.topic(id), Msg{Attributes: mergeMaps(attrs...), Data: d})
+}
+
+// PublishWithOrderingKey publishes the data to the topic in the same way as
+// Publish, with the ordering key set. Messages with the same ordering key are
+// delivered in the order they were published to subscriptions created with
+// WithMessageOrdering.
+func (p *PubSub) PublishWithOrderingKey(id string, key string, d any, attrs ...map[string]string) error {
+	_, err := p.publishAsync(p.ctx, p.topic(id), Msg{Attributes: mergeMaps(attrs...), Data: d, OrderingKey: key}).Get(p.ctx)
+	return err
+}
 
-	// ensure data to published is set
-	if dta == nil {
-		dta = d.([]byte)
+func (p *PubSub) publish(id string, c Codec, d any, attrs ...map[string]string) error {
+	res := p.publishAsync(p.ctx, p.topic(id), Msg{Attributes: mergeMaps(attrs...), Codec: c, Data: d})
+
+	// get the result to ensure message was published
+	if _, err := res.Get(p.ctx); err != nil {
+		return err
 	}
 
-	// apply OriginatedAt attribute
-	mgd := mergeMaps(attrs...)
+	return nil
+}
 
-	// set OriginatedAt attribute if not set and AutoOriginatedAt is true
-	if _, ok := mgd["OriginatedAt"]; p.opts.AutoOriginatedAt && !ok {
-		mgd["OriginatedAt"] = fmt.Sprintf("%v", time.Now().Unix())
+// publishAsync prepares the message and hands it to the topic. Errors that
+// occur before the message is sent are returned through the PublishResult.
+func (p *PubSub) publishAsync(ctx context.Context, t Topic, m Msg) PublishResult {
+	mgd := mergeMaps(m.Attributes)
+
+	// marshal provided data with the codec if needed
+	dta, err := encode(m.Codec, p.opts.Codec, m.Data, mgd)
+	if err != nil {
+		p.logPublishError(ctx, t.ID(), m.OrderingKey, mgd, err)
+		return resolvedPublishResult("", err)
 	}
 
-	// publish the message
-	res := t.Publish(p.ctx, &pubsub.Message{
-		Data:       dta,
-		Attributes: mgd,
+	// publish the message through the middleware, which wait for the result
+	// on a single goroutine
+	cbs := &publishCallbacks{}
+	res := p.publishChain(t)(context.WithValue(ctx, publishCallbacksKey{}, cbs), t.ID(), &pubsub.Message{
+		Data:        dta,
+		Attributes:  mgd,
+		OrderingKey: m.OrderingKey,
 	})
 
-	// get the result to ensure message was published
-	if _, err := res.Get(p.ctx); err != nil {
-		return err
+	// a failure pauses the ordering key, so resume it for the next message
+	if m.OrderingKey != "" || p.opts.Logger != nil {
+		cbs.add(res, func() {
+			if _, err := res.Get(context.Background()); err != nil {
+				p.logPublishError(ctx, t.ID(), m.OrderingKey, mgd, err)
+				if m.OrderingKey != "" {
+					t.ResumePublish(m.OrderingKey)
+				}
+			}
+		})
 	}
+	cbs.run()
 
-	return nil
+	return res
+}
+
+// logPublishError logs a message that failed to be published to the topic.
+func (p *PubSub) logPublishError(ctx context.Context, id string, key string, attrs map[string]string, err error) {
+	as := []slog.Attr{slog.String("topic", id)}
+	if key != "" {
+		as = append(as, slog.String("ordering_key", key))
+	}
+
+	p.log(ctx, slog.LevelError, "failed to publish message", append(as, p.logAttributes(attrs), slog.Any("error", err))...)
+}
+
+// topic returns the cached handle to the topic, creating it with the
+// PublishSettings applied on first use, so that messages published to the topic
+// share a bundler and are batched.
+func (p *PubSub) topic(id string) Topic {
+	p.tmu.Lock()
+	defer p.tmu.Unlock()
+
+	if t, ok := p.topics[id]; ok {
+		return t
+	}
+
+	t := p.clnt.Topic(id)
+
+	// apply PublishSettings, and allow ordering keys, which does not affect
+	// messages without one
+	p.ensurePublishSettings()
+	t.SetPublishSettings(p.opts.PublishSettings)
+	t.SetMessageOrdering(true)
+
+	if p.topics == nil {
+		p.topics = make(map[string]Topic)
+	}
+	p.topics[id] = t
+
+	return t
 }
 
+// Receive subscribes to a topic via the subscription id and sends each
+// underlying pubsub.Message to the channel, decrypted and decompressed but
+// without the receive middleware. Messages that cannot be decrypted or
+// decompressed are nacked.
 func (p *PubSub) Receive(id string, mc chan<- *pubsub.Message) error {
-	sub := p.clnt.Subscription(id)
+	sub, ok := p.clnt.Subscription(id).(rawReceiver)
+	if !ok {
+		return ErrRawReceiveUnsupported
+	}
+
 	p.ensureReceiveSettings()
-	sub.ReceiveSettings = p.opts.ReceiveSettings
 
-	return sub.Receive(p.ctx, func(ctx context.Context, m *pubsub.Message) {
+	return sub.receiveRaw(p.ctx, p.opts.ReceiveSettings, func(ctx context.Context, m *pubsub.Message) {
+		msg := &Message{ID: m.ID, Data: m.Data, Attributes: m.Attributes}
+		if err := p.decode(ctx, id, msg); err != nil {
+			m.Nack()
+			return
+		}
+
+		m.Data = msg.Data
+		m.Attributes = msg.Attributes
 		mc <- m
 	})
 }
 
-func NewPubSub(ctx context.Context, opts *PubSubOptions) (*PubSub, error) {
-	clnt, err := pubsub.NewClient(ctx, opts.ProjectID, opts.ClientOptions...)
+// ReceiveMessages subscribes to a topic via the subscription id and sends each
+// message to the channel until the context is done. Unlike Receive, it works
+// with every Backend. The caller must Ack or Nack each message; messages that
+// cannot be sent before the context is done are nacked.
+func (p *PubSub) ReceiveMessages(ctx context.Context, id string, mc chan<- *Message) error {
+	return p.receive(ctx, id, func(ctx context.Context, msg *Message) {
+		select {
+		case mc <- msg:
+		case <-ctx.Done():
+			msg.Nack()
+		}
+	})
+}
+
+// ReceiveFunc subscribes to a topic via the subscription id and calls the
+// handler, wrapped with the receive middleware, for each message until the
+// context is done. Messages are acked when the handler returns nil and nacked
+// when it returns an error or panics.
+func (p *PubSub) ReceiveFunc(ctx context.Context, id string, h Handler) error {
+	return p.receiveFunc(ctx, id, p.receiveChain(id, h))
+}
+
+// receiveFunc calls the handler for each message, acking or nacking it by the
+// result, without applying the receive middleware.
+func (p *PubSub) receiveFunc(ctx context.Context, id string, h Handler) error {
+	return p.receive(ctx, id, func(ctx context.Context, msg *Message) {
+		if err := handle(ctx, h, msg); err != nil {
+			p.log(ctx, slog.LevelWarn, "failed to handle message",
+				slog.String("subscription", id),
+				slog.String("message_id", msg.ID),
+				slog.Any("error", err),
+			)
+			msg.Nack()
+			return
+		}
+
+		msg.Ack()
+	})
+}
+
+func (p *PubSub) receive(ctx context.Context, id string, f func(context.Context, *Message)) error {
+	p.ensureReceiveSettings()
+	p.log(ctx, slog.LevelInfo, "receiving messages", slog.String("subscription", id))
+
+	err := p.clnt.Subscription(id).Receive(ctx, p.opts.ReceiveSettings, func(ctx context.Context, m *Message) {
+		m.codec = p.opts.Codec
+		m.Subscription = id
+		if p.opts.Metrics != nil {
+			p.opts.Metrics.receivedMessage(m)
+		}
+		if p.opts.Logger != nil {
+			p.loggedMessage(m)
+		}
+
+		// handlers always see the original data
+		if err := p.decode(ctx, id, m); err != nil {
+			m.Nack()
+			return
+		}
+
+		f(ctx, m)
+	})
 	if err != nil {
-		return nil, err
+		p.log(ctx, slog.LevelError, "stopped receiving messages", slog.String("subscription", id), slog.Any("error", err))
+		return err
+	}
+
+	p.log(ctx, slog.LevelInfo, "stopped receiving messages", slog.String("subscription", id))
+	return nil
+}
+
+// decode decrypts and decompresses the data of a message received from the
+// subscription, logging the error when it cannot.
+func (p *PubSub) decode(ctx context.Context, id string, m *Message) error {
+	if err := decrypt(ctx, p.opts.KeyProvider, m); err != nil {
+		p.log(ctx, slog.LevelError, "failed to decrypt message", slog.String("subscription", id), slog.String("message_id", m.ID), slog.Any("error", err))
+		return err
+	}
+
+	// without a key provider the data stays encrypted, and compressed
+	if _, ok := m.Attributes[EncryptionKeyAttribute]; ok {
+		return nil
+	}
+
+	if err := decompress(m); err != nil {
+		p.log(ctx, slog.LevelError, "failed to decompress message", slog.String("subscription", id), slog.String("message_id", m.ID), slog.Any("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func NewPubSub(ctx context.Context, opts *PubSubOptions) (*PubSub, error) {
+	// use the provided backend, or connect to Google Cloud Pub/Sub
+	var clnt Backend = opts.Backend
+	if clnt == nil {
+		gcp, err := newGCPBackend(ctx, opts.ProjectID, opts.ClientOptions...)
+		if err != nil {
+			return nil, err
+		}
+
+		clnt = gcp
 	}
 
 	return &PubSub{
-		clnt: clnt,
-		ctx:  ctx,
-		opts: opts,
+		clnt:       clnt,
+		ctx:        ctx,
+		opts:       opts,
+		validators: make(map[string]SchemaValidator),
 	}, nil
 }
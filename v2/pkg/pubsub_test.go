@@ -0,0 +1,217 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func newTestPubSub(t *testing.T) (*PubSub, *pstest.Server) {
+	t.Helper()
+
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ps, err := NewPubSub(ctx, Options("test-project", option.WithGRPCConn(conn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		ps.Close()
+	})
+
+	return ps, srv
+}
+
+// forEachBackend runs f as a subtest with a PubSub for each backend, the
+// in-memory backend and Google Cloud Pub/Sub through a fake server.
+func forEachBackend(t *testing.T, f func(t *testing.T, ps *PubSub)) {
+	t.Helper()
+
+	backends := map[string]func(t *testing.T) *PubSub{
+		"memory": newMemoryPubSub,
+		"gcp": func(t *testing.T) *PubSub {
+			ps, _ := newTestPubSub(t)
+			return ps
+		},
+	}
+	for name, newPubSub := range backends {
+		t.Run(name, func(t *testing.T) {
+			f(t, newPubSub(t))
+		})
+	}
+}
+
+func TestPubSub_ReceiveFunc(t *testing.T) {
+	tests := []struct {
+		name  string
+		first func()
+	}{
+		{
+			"should nack and redeliver when the handler returns an error",
+			func() {},
+		},
+		{
+			"should nack and redeliver when the handler panics",
+			func() { panic("boom") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, srv := newTestPubSub(t)
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.Publish("topic", "hello world"); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// fail the first delivery and succeed the second
+			var calls int32
+			err := ps.ReceiveFunc(ctx, "sub", func(ctx context.Context, m *Message) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					tt.first()
+					return errors.New("failed")
+				}
+
+				cancel()
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ReceiveFunc() error = %v", err)
+			}
+
+			if got := atomic.LoadInt32(&calls); got != 2 {
+				t.Fatalf("handler called %d times, want 2", got)
+			}
+			if got := srv.Messages()[0].Acks; got != 1 {
+				t.Errorf("message acked %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestPubSub_ReceiveMessages(t *testing.T) {
+	ps := newMemoryPubSub(t)
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// nack the first delivery and ack the redelivery
+	mc := make(chan *Message)
+	errc := make(chan error, 1)
+	go func() { errc <- ps.ReceiveMessages(ctx, "sub", mc) }()
+
+	first := <-mc
+	first.Nack()
+
+	second := <-mc
+	second.Ack()
+	cancel()
+
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveMessages() error = %v", err)
+	}
+	if first.ID != second.ID || string(second.Data) != "hello world" {
+		t.Errorf("ReceiveMessages() redelivered %s %q, want %s", second.ID, second.Data, first.ID)
+	}
+}
+
+// stallingBackend returns topics whose Stop blocks until release is closed.
+type stallingBackend struct {
+	Backend
+	release chan struct{}
+}
+
+func (b *stallingBackend) Topic(id string) Topic {
+	return &stallingTopic{b.Backend.Topic(id), b.release}
+}
+
+type stallingTopic struct {
+	Topic
+	release chan struct{}
+}
+
+func (t *stallingTopic) Stop() {
+	<-t.release
+}
+
+func TestPubSub_Close(t *testing.T) {
+	ps, _ := newTestPubSub(t)
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ps.topic("topic") != ps.topic("topic") {
+		t.Error("topic() should return the cached topic handle")
+	}
+
+	// hold the message in the bundler so that Close has to flush it
+	ps.opts.SetPublishSettings(pubsub.PublishSettings{DelayThreshold: time.Hour, CountThreshold: 100})
+	ps.topics = nil
+
+	res := ps.PublishAsync("topic", "hello world")
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := res.Get(ctx); err != nil {
+		t.Errorf("PublishAsync().Get() error = %v, want the message to be flushed by Close", err)
+	}
+}
+
+func TestPubSub_Close_Timeout(t *testing.T) {
+	b := &stallingBackend{NewMemoryBackend(), make(chan struct{})}
+	defer close(b.release)
+
+	ps, err := NewPubSub(context.Background(), Options("test-project").SetBackend(b).SetShutdownTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("topic", "hello world"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := ps.Close(); err == nil {
+		t.Error("Close() error = nil, want a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close() took %v, want it to give up after the shutdown timeout", elapsed)
+	}
+}
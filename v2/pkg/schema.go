@@ -0,0 +1,264 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/bufbuild/protocompile"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	// ErrSchemasUnsupported is returned when the Backend does not support
+	// schemas.
+	ErrSchemasUnsupported = errors.New("backend does not support schemas")
+
+	// ErrSchemaEncodedData is the error of the SchemaError returned by Publish
+	// for messages to a topic with a schema whose data was compressed or
+	// encrypted, which cannot conform to the schema.
+	ErrSchemaEncodedData = errors.New("compressed or encrypted data cannot be validated")
+)
+
+// SchemaBackend is implemented by Backends that can register schemas.
+type SchemaBackend interface {
+	CreateSchema(ctx context.Context, id string, cfg pubsub.SchemaConfig) (*pubsub.SchemaConfig, error)
+	Schema(ctx context.Context, id string) (*pubsub.SchemaConfig, error)
+}
+
+// SchemaValidator validates message data against a schema.
+type SchemaValidator interface {
+	Validate(data []byte) error
+}
+
+// SchemaError is returned by Publish when the data does not conform to the
+// schema of the topic it is being published to.
+type SchemaError struct {
+	Err   error
+	Topic string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("message for topic %s does not conform to its schema: %v", e.Topic, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// NewSchemaValidator returns a SchemaValidator for an Avro or Protocol Buffer
+// schema definition and the encoding that messages are published with.
+func NewSchemaValidator(cfg pubsub.SchemaConfig, enc pubsub.SchemaEncoding) (SchemaValidator, error) {
+	if enc != pubsub.EncodingJSON && enc != pubsub.EncodingBinary {
+		return nil, fmt.Errorf("unsupported schema encoding %v", enc)
+	}
+
+	switch cfg.Type {
+	case pubsub.SchemaAvro:
+		return newAvroValidator(cfg.Definition, enc)
+	case pubsub.SchemaProtocolBuffer:
+		return newProtoValidator(cfg.Definition, enc)
+	default:
+		return nil, fmt.Errorf("unsupported schema type %v", cfg.Type)
+	}
+}
+
+type avroValidator struct {
+	codec *goavro.Codec
+	json  bool
+}
+
+func newAvroValidator(def string, enc pubsub.SchemaEncoding) (*avroValidator, error) {
+	c, err := goavro.NewCodec(def)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+
+	return &avroValidator{
+		codec: c,
+		json:  enc == pubsub.EncodingJSON,
+	}, nil
+}
+
+func (v *avroValidator) Validate(data []byte) error {
+	var rest []byte
+	var err error
+	if v.json {
+		_, rest, err = v.codec.NativeFromTextual(data)
+	} else {
+		_, rest, err = v.codec.NativeFromBinary(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		return fmt.Errorf("%d unexpected bytes after the avro record", len(rest))
+	}
+
+	return nil
+}
+
+type protoValidator struct {
+	desc protoreflect.MessageDescriptor
+	json bool
+}
+
+func newProtoValidator(def string, enc pubsub.SchemaEncoding) (*protoValidator, error) {
+	// compile the definition as a single file
+	comp := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{"schema.proto": def}),
+		}),
+	}
+	fds, err := comp.Compile(context.Background(), "schema.proto")
+	if err != nil {
+		return nil, fmt.Errorf("invalid protocol buffer schema: %w", err)
+	}
+
+	// Pub/Sub requires exactly one top-level message type
+	msgs := fds[0].Messages()
+	if msgs.Len() != 1 {
+		return nil, fmt.Errorf("invalid protocol buffer schema: expected 1 top-level message type, found %d", msgs.Len())
+	}
+
+	return &protoValidator{
+		desc: msgs.Get(0),
+		json: enc == pubsub.EncodingJSON,
+	}, nil
+}
+
+func (v *protoValidator) Validate(data []byte) error {
+	m := dynamicpb.NewMessage(v.desc)
+	if v.json {
+		return protojson.Unmarshal(data, m)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+// CreateSchema registers a schema with the provided ID if it does not already
+// exist.
+func (p *PubSub) CreateSchema(id string, cfg pubsub.SchemaConfig) error {
+	sb, ok := p.clnt.(SchemaBackend)
+	if !ok {
+		return ErrSchemasUnsupported
+	}
+
+	// check to see if the requested schema already exists
+	if _, err := sb.Schema(p.ctx, id); status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	// ensure the definition is valid before registering it
+	if _, err := NewSchemaValidator(cfg, pubsub.EncodingBinary); err != nil {
+		return err
+	}
+
+	_, err := sb.CreateSchema(p.ctx, id, cfg)
+	return err
+}
+
+// CreateTopicWithSchema creates a topic whose messages must conform to the
+// schema with the provided ID and encoding, if the topic does not already
+// exist. Messages published to the topic are validated against the schema
+// before they are sent, and Publish returns a SchemaError for those that do
+// not conform.
+func (p *PubSub) CreateTopicWithSchema(id string, sid string, enc pubsub.SchemaEncoding, cfg ...pubsub.TopicConfig) error {
+	sb, ok := p.clnt.(SchemaBackend)
+	if !ok {
+		return ErrSchemasUnsupported
+	}
+
+	sc, err := sb.Schema(p.ctx, sid)
+	if err != nil {
+		return err
+	}
+
+	v, err := NewSchemaValidator(*sc, enc)
+	if err != nil {
+		return err
+	}
+
+	// attach the schema to the topic configuration
+	tc := pubsub.TopicConfig{}
+	if len(cfg) > 0 {
+		tc = cfg[0]
+	}
+	tc.SchemaSettings = &pubsub.SchemaSettings{
+		Schema:   sc.Name,
+		Encoding: enc,
+	}
+
+	if err := p.CreateTopic(id, tc); err != nil {
+		return err
+	}
+
+	p.SetSchemaValidator(id, v)
+
+	return nil
+}
+
+// SetSchemaValidator sets the validator that messages published to the topic
+// are checked with before they are sent. This is useful for topics with a
+// schema that were not created with CreateTopicWithSchema. Providing a nil
+// validator removes validation for the topic.
+func (p *PubSub) SetSchemaValidator(id string, v SchemaValidator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v == nil {
+		delete(p.validators, id)
+		return
+	}
+
+	p.validators[id] = v
+}
+
+// validateMiddleware fails the messages that do not conform to the schema of
+// the topic instead of publishing them.
+func (p *PubSub) validateMiddleware(next PublishFunc) PublishFunc {
+	return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+		if err := p.validate(id, m); err != nil {
+			return FailedPublishResult(err)
+		}
+
+		return next(ctx, id, m)
+	}
+}
+
+// validate checks the data of the message against the schema validator for
+// the topic, if any, once it has passed through the publish middleware.
+func (p *PubSub) validate(id string, m *pubsub.Message) error {
+	p.mu.RLock()
+	v, ok := p.validators[id]
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	_, enc := m.Attributes[EncryptionKeyAttribute]
+	if _, ok := m.Attributes[ContentEncodingAttribute]; ok || enc {
+		return &SchemaError{
+			Err:   ErrSchemaEncodedData,
+			Topic: id,
+		}
+	}
+
+	if err := v.Validate(m.Data); err != nil {
+		return &SchemaError{
+			Err:   err,
+			Topic: id,
+		}
+	}
+
+	return nil
+}
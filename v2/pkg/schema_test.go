@@ -0,0 +1,166 @@
+package pb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	testAvroSchema = `{
+		"type": "record",
+		"name": "Example",
+		"fields": [
+			{"name": "coolNumber", "type": "int"},
+			{"name": "message", "type": "string"}
+		]
+	}`
+
+	testProtoSchema = `syntax = "proto3";
+		message Example {
+			int32 cool_number = 1;
+			string message = 2;
+		}`
+)
+
+func TestNewSchemaValidator(t *testing.T) {
+	avro := pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: testAvroSchema}
+	proto := pubsub.SchemaConfig{Type: pubsub.SchemaProtocolBuffer, Definition: testProtoSchema}
+
+	// binary encodings of {coolNumber: 13, message: "hi"}
+	avroBinary := []byte{26, 4, 'h', 'i'}
+	protoBinary := protowire.AppendTag(nil, 1, protowire.VarintType)
+	protoBinary = protowire.AppendVarint(protoBinary, 13)
+	protoBinary = protowire.AppendTag(protoBinary, 2, protowire.BytesType)
+	protoBinary = protowire.AppendString(protoBinary, "hi")
+
+	tests := []struct {
+		name    string
+		cfg     pubsub.SchemaConfig
+		enc     pubsub.SchemaEncoding
+		data    []byte
+		wantErr bool
+	}{
+		{"avro JSON should accept a valid record", avro, pubsub.EncodingJSON, []byte(`{"coolNumber":13,"message":"hi"}`), false},
+		{"avro JSON should reject a missing field", avro, pubsub.EncodingJSON, []byte(`{"coolNumber":13}`), true},
+		{"avro JSON should reject the wrong type", avro, pubsub.EncodingJSON, []byte(`{"coolNumber":"13","message":"hi"}`), true},
+		{"avro binary should accept a valid record", avro, pubsub.EncodingBinary, avroBinary, false},
+		{"avro binary should reject trailing bytes", avro, pubsub.EncodingBinary, append(avroBinary, 0), true},
+		{"proto JSON should accept a valid message", proto, pubsub.EncodingJSON, []byte(`{"coolNumber":13,"message":"hi"}`), false},
+		{"proto JSON should reject unknown fields", proto, pubsub.EncodingJSON, []byte(`{"region":"CA"}`), true},
+		{"proto binary should accept a valid message", proto, pubsub.EncodingBinary, protoBinary, false},
+		{"proto binary should reject invalid wire data", proto, pubsub.EncodingBinary, []byte{0xff}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewSchemaValidator(tt.cfg, tt.enc)
+			if err != nil {
+				t.Fatalf("NewSchemaValidator() error = %v", err)
+			}
+			if err := v.Validate(tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSchemaValidator_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  pubsub.SchemaConfig
+		enc  pubsub.SchemaEncoding
+	}{
+		{"should reject an invalid avro definition", pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: `{"type":"nope"}`}, pubsub.EncodingJSON},
+		{"should reject an invalid proto definition", pubsub.SchemaConfig{Type: pubsub.SchemaProtocolBuffer, Definition: `message {`}, pubsub.EncodingJSON},
+		{"should reject multiple top-level messages", pubsub.SchemaConfig{Type: pubsub.SchemaProtocolBuffer, Definition: `syntax = "proto3"; message A {} message B {}`}, pubsub.EncodingJSON},
+		{"should reject an unknown type", pubsub.SchemaConfig{Definition: testAvroSchema}, pubsub.EncodingJSON},
+		{"should reject an unknown encoding", pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: testAvroSchema}, pubsub.EncodingUnspecified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSchemaValidator(tt.cfg, tt.enc); err == nil {
+				t.Error("NewSchemaValidator() expected an error")
+			}
+		})
+	}
+}
+
+func TestPubSub_CreateTopicWithSchema(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		cfg := pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: testAvroSchema}
+		if err := ps.CreateSchema("example", cfg); err != nil {
+			t.Fatalf("CreateSchema() error = %v", err)
+		}
+		if err := ps.CreateSchema("example", cfg); err != nil {
+			t.Fatalf("CreateSchema() should skip existing schemas, error = %v", err)
+		}
+		if err := ps.CreateTopicWithSchema("topic", "example", pubsub.EncodingJSON); err != nil {
+			t.Fatalf("CreateTopicWithSchema() error = %v", err)
+		}
+
+		if err := ps.Publish("topic", typedExample{13, "hello world"}); err != nil {
+			t.Errorf("Publish() error = %v", err)
+		}
+
+		var se *SchemaError
+		if err := ps.Publish("topic", map[string]string{"region": "CA"}); !errors.As(err, &se) {
+			t.Errorf("Publish() error = %v, want SchemaError", err)
+		}
+	})
+}
+
+func TestPubSub_CreateTopicWithSchema_Encoded(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts func(o *PubSubOptions)
+	}{
+		{
+			"should reject compressed data",
+			func(o *PubSubOptions) { o.SetPublishMiddleware(Compress(GzipCompressor, 0)) },
+		},
+		{
+			"should reject encrypted data",
+			func(o *PubSubOptions) { o.SetKeyProvider(kp) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			tt.opts(ps.opts)
+
+			cfg := pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: testAvroSchema}
+			if err := ps.CreateSchema("example", cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := ps.CreateTopicWithSchema("topic", "example", pubsub.EncodingJSON); err != nil {
+				t.Fatal(err)
+			}
+
+			// large enough to be compressed
+			err := ps.Publish("topic", typedExample{13, strings.Repeat("hello world ", 100)})
+			if !errors.Is(err, ErrSchemaEncodedData) {
+				t.Errorf("Publish() error = %v, want ErrSchemaEncodedData", err)
+			}
+		})
+	}
+}
+
+func TestPubSub_CreateSchema_Invalid(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	cfg := pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: `{"type":"nope"}`}
+	if err := ps.CreateSchema("example", cfg); err == nil {
+		t.Error("CreateSchema() expected an error for an invalid definition")
+	}
+	if err := ps.CreateTopicWithSchema("topic", "missing", pubsub.EncodingJSON); err == nil {
+		t.Error("CreateTopicWithSchema() expected an error for a missing schema")
+	}
+}
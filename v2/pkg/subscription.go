@@ -0,0 +1,133 @@
+package pb
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// SubscriptionOption configures a subscription created with
+// CreateSubscriptionWithOptions.
+type SubscriptionOption func(*subscriptionSettings)
+
+type subscriptionSettings struct {
+	base pubsub.SubscriptionConfig
+	mods []func(cfg *pubsub.SubscriptionConfig)
+	dlt  string
+	max  int
+}
+
+// config returns the base configuration with the other options applied to it.
+func (s *subscriptionSettings) config() pubsub.SubscriptionConfig {
+	cfg := s.base
+	for _, mod := range s.mods {
+		mod(&cfg)
+	}
+
+	return cfg
+}
+
+// WithSubscriptionConfig uses the configuration as the base that the other
+// options are applied to, wherever it is provided among them.
+func WithSubscriptionConfig(cfg pubsub.SubscriptionConfig) SubscriptionOption {
+	return func(s *subscriptionSettings) {
+		s.base = cfg
+	}
+}
+
+// WithDeadLetter forwards messages to the dead letter topic once they have been
+// delivered max times, which must be from 5 to 100 (5 when zero). The topic is
+// created if it does not exist, along with a catch-all subscription named by
+// DeadLetterSubscriptionID so that forwarded messages are retained. Google
+// Cloud Pub/Sub also requires its service account to be allowed to publish to
+// the dead letter topic and subscribe to the subscription.
+func WithDeadLetter(tid string, max int) SubscriptionOption {
+	return func(s *subscriptionSettings) {
+		s.dlt = tid
+		s.max = max
+	}
+}
+
+// WithRetryPolicy redelivers nacked messages after an exponential backoff that
+// starts at min and is capped at max, rather than immediately.
+func WithRetryPolicy(min time.Duration, max time.Duration) SubscriptionOption {
+	return func(s *subscriptionSettings) {
+		s.mods = append(s.mods, func(cfg *pubsub.SubscriptionConfig) {
+			cfg.RetryPolicy = &pubsub.RetryPolicy{
+				MinimumBackoff: min,
+				MaximumBackoff: max,
+			}
+		})
+	}
+}
+
+// WithExactlyOnceDelivery guarantees that a message is not redelivered once it
+// has been acked successfully, and that it is not redelivered while its ack
+// deadline has not passed. Acks must be confirmed with Message.AckWithResult or
+// ReceiveConfirmed to know that they succeeded.
+func WithExactlyOnceDelivery() SubscriptionOption {
+	return func(s *subscriptionSettings) {
+		s.mods = append(s.mods, func(cfg *pubsub.SubscriptionConfig) {
+			cfg.EnableExactlyOnceDelivery = true
+		})
+	}
+}
+
+// WithMessageOrdering delivers messages with the same ordering key in the order
+// they were published, one at a time. It can only be set when the subscription
+// is created.
+func WithMessageOrdering() SubscriptionOption {
+	return func(s *subscriptionSettings) {
+		s.mods = append(s.mods, func(cfg *pubsub.SubscriptionConfig) {
+			cfg.EnableMessageOrdering = true
+		})
+	}
+}
+
+// validDeliveryAttempts reports whether the max delivery attempts of a dead
+// letter policy are zero, for the default, or within the 5 to 100 that Google
+// Cloud Pub/Sub allows.
+func validDeliveryAttempts(max int) bool {
+	return max == 0 || (max >= 5 && max <= 100)
+}
+
+// DeadLetterSubscriptionID returns the ID of the catch-all subscription that
+// WithDeadLetter creates for the dead letter topic.
+func DeadLetterSubscriptionID(tid string) string {
+	return tid + "-sub"
+}
+
+// CreateSubscriptionWithOptions creates a subscription to the topic with the
+// provided filter and options in the same way as CreateSubscription.
+func (p *PubSub) CreateSubscriptionWithOptions(id string, sid string, fltr string, opts ...SubscriptionOption) error {
+	s := &subscriptionSettings{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	cfg := s.config()
+
+	// provision the dead letter topic and its catch-all subscription
+	if s.dlt != "" {
+		if !validDeliveryAttempts(s.max) {
+			return fmt.Errorf("dead letter topic %s must have between 5 and 100 max delivery attempts, not %d", s.dlt, s.max)
+		}
+
+		if err := p.CreateTopic(s.dlt); err != nil {
+			return err
+		}
+		if err := p.CreateSubscription(s.dlt, DeadLetterSubscriptionID(s.dlt), ""); err != nil {
+			return err
+		}
+
+		cfg.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     p.clnt.Topic(s.dlt).String(),
+			MaxDeliveryAttempts: s.max,
+		}
+		if s.max == 0 {
+			cfg.DeadLetterPolicy.MaxDeliveryAttempts = defaultMaxDeliveryAttempts
+		}
+	}
+
+	return p.CreateSubscription(id, sid, fltr, cfg)
+}
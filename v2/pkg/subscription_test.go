@@ -0,0 +1,157 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPubSub_CreateSubscriptionWithOptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		ctx := context.Background()
+
+		if err := ps.CreateTopic("topic"); err != nil {
+			t.Fatalf("CreateTopic() error = %v", err)
+		}
+		if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithDeadLetter("topic-dlq", 0), WithRetryPolicy(time.Second, time.Minute)); err != nil {
+			t.Fatalf("CreateSubscriptionWithOptions() error = %v", err)
+		}
+
+		if exists, _ := ps.clnt.Subscription(DeadLetterSubscriptionID("topic-dlq")).Exists(ctx); !exists {
+			t.Error("CreateSubscriptionWithOptions() should create the dead letter subscription")
+		}
+
+		cfg, err := ps.clnt.Subscription("sub").Config(ctx)
+		if err != nil {
+			t.Fatalf("Config() error = %v", err)
+		}
+		if got := formatDeadLetter(cfg.DeadLetterPolicy); got != "topic-dlq after 5 attempts" {
+			t.Errorf("Config().DeadLetterPolicy = %s, want topic-dlq after 5 attempts", got)
+		}
+		if got := formatRetryPolicy(cfg.RetryPolicy); got != "1s to 1m0s" {
+			t.Errorf("Config().RetryPolicy = %s, want 1s to 1m0s", got)
+		}
+
+		// creating it again should not report drift
+		if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithDeadLetter("topic-dlq", 5), WithRetryPolicy(time.Second, time.Minute)); err != nil {
+			t.Errorf("CreateSubscriptionWithOptions() error = %v", err)
+		}
+	})
+}
+
+func TestPubSub_CreateSubscriptionWithOptions_DeliveryAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		wantErr bool
+	}{
+		{"should use the default when zero", 0, false},
+		{"should accept the minimum", 5, false},
+		{"should accept the maximum", 100, false},
+		{"should reject too few attempts", 4, true},
+		{"should reject too many attempts", 101, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newMemoryPubSub(t)
+			if err := ps.CreateTopic("topic"); err != nil {
+				t.Fatalf("CreateTopic() error = %v", err)
+			}
+
+			err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithDeadLetter("topic-dlq", tt.max))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateSubscriptionWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryBackend_DeadLetter(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatalf("CreateTopic() error = %v", err)
+	}
+	if err := ps.CreateSubscriptionWithOptions("topic", "sub", "", WithDeadLetter("topic-dlq", 5), WithRetryPolicy(10*time.Millisecond, 40*time.Millisecond)); err != nil {
+		t.Fatalf("CreateSubscriptionWithOptions() error = %v", err)
+	}
+	if err := ps.Publish("topic", []byte("hello")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	// nack every delivery until the message is dead lettered
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var attempts []int
+	start := time.Now()
+	err := ps.ReceiveFunc(ctx, "sub", func(ctx context.Context, m *Message) error {
+		attempts = append(attempts, m.DeliveryAttempt)
+		return errors.New("nope")
+	})
+	if err != nil {
+		t.Fatalf("ReceiveFunc() error = %v", err)
+	}
+
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("DeliveryAttempt = %v, want %v", attempts, want)
+	}
+
+	// wait for the dead lettered message
+	var attrs map[string]string
+	got := receiveN(t, ps, DeadLetterSubscriptionID("topic-dlq"), 1, func(ctx context.Context, m *Message) error {
+		attrs = m.Attributes
+		return nil
+	})
+	if got[0] != "hello" {
+		t.Errorf("dead lettered message = %s, want hello", got[0])
+	}
+	if attrs["CloudPubSubDeadLetterSourceDeliveryCount"] != "5" {
+		t.Errorf("dead lettered message attributes = %v, want a delivery count of 5", attrs)
+	}
+
+	// the retries back off for 10ms, 20ms, 40ms and 40ms
+	if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+		t.Errorf("redelivered after %v, want at least 110ms of backoff", elapsed)
+	}
+}
+
+func TestSubscriptionSettings_Config(t *testing.T) {
+	base := pubsub.SubscriptionConfig{AckDeadline: 30 * time.Second}
+	tests := []struct {
+		name string
+		opts []SubscriptionOption
+	}{
+		{
+			"should apply the options to the base config",
+			[]SubscriptionOption{WithSubscriptionConfig(base), WithRetryPolicy(time.Second, time.Minute), WithMessageOrdering()},
+		},
+		{
+			"should apply the base config before the options that precede it",
+			[]SubscriptionOption{WithRetryPolicy(time.Second, time.Minute), WithMessageOrdering(), WithSubscriptionConfig(base)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &subscriptionSettings{}
+			for _, opt := range tt.opts {
+				opt(s)
+			}
+
+			cfg := s.config()
+			if cfg.AckDeadline != 30*time.Second {
+				t.Errorf("config().AckDeadline = %v, want 30s", cfg.AckDeadline)
+			}
+			if got := formatRetryPolicy(cfg.RetryPolicy); got != "1s to 1m0s" {
+				t.Errorf("config().RetryPolicy = %s, want 1s to 1m0s", got)
+			}
+			if !cfg.EnableMessageOrdering {
+				t.Error("config().EnableMessageOrdering = false, want true")
+			}
+		})
+	}
+}
@@ -0,0 +1,643 @@
+package pb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"gopkg.in/yaml.v3"
+)
+
+// TopologyLabel is the label that identifies the topology a topic or
+// subscription is managed by. Reconcile sets it on every resource of a named
+// topology, and only deletes resources that carry it when pruning.
+const TopologyLabel = "pubsub-go-topology"
+
+// label values may only contain lowercase letters, digits, underscores and
+// dashes
+var labelValue = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+
+// Topology declares the topics and subscriptions that a service depends on so
+// that they can be reconciled from a single YAML or JSON document.
+//
+// When Name is set, every topic and subscription in the topology is labelled
+// with it, and setting Prune deletes the labelled resources that are no longer
+// declared. Resources created by other means or by other topologies are never
+// deleted.
+type Topology struct {
+	Name          string             `json:"name,omitempty" yaml:"name,omitempty"`
+	Prune         bool               `json:"prune,omitempty" yaml:"prune,omitempty"`
+	Topics        []TopicSpec        `json:"topics,omitempty" yaml:"topics,omitempty"`
+	Subscriptions []SubscriptionSpec `json:"subscriptions,omitempty" yaml:"subscriptions,omitempty"`
+}
+
+// TopicSpec declares a topic. A zero Retention disables message retention on
+// the topic.
+type TopicSpec struct {
+	ID        string            `json:"id" yaml:"id"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Retention Duration          `json:"retention,omitempty" yaml:"retention,omitempty"`
+}
+
+// SubscriptionSpec declares a subscription. A zero AckDeadline or Retention
+// uses the Google Cloud Pub/Sub default of 10 seconds or 7 days respectively.
+// The Topic and Filter of a subscription cannot be changed once it has been
+// created.
+type SubscriptionSpec struct {
+	ID          string            `json:"id" yaml:"id"`
+	Topic       string            `json:"topic" yaml:"topic"`
+	AckDeadline Duration          `json:"ackDeadline,omitempty" yaml:"ackDeadline,omitempty"`
+	DeadLetter  *DeadLetterSpec   `json:"deadLetter,omitempty" yaml:"deadLetter,omitempty"`
+	Filter      string            `json:"filter,omitempty" yaml:"filter,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	RetainAcked bool              `json:"retainAcked,omitempty" yaml:"retainAcked,omitempty"`
+	Retention   Duration          `json:"retention,omitempty" yaml:"retention,omitempty"`
+	Retry       *RetrySpec        `json:"retry,omitempty" yaml:"retry,omitempty"`
+}
+
+// DeadLetterSpec declares the topic that messages are forwarded to once they
+// have been delivered MaxDeliveryAttempts times (5 when zero).
+type DeadLetterSpec struct {
+	Topic               string `json:"topic" yaml:"topic"`
+	MaxDeliveryAttempts int    `json:"maxDeliveryAttempts,omitempty" yaml:"maxDeliveryAttempts,omitempty"`
+}
+
+// RetrySpec declares the backoff between redeliveries of nacked messages. Zero
+// values use the Google Cloud Pub/Sub defaults of 10 seconds and 10 minutes.
+type RetrySpec struct {
+	MinimumBackoff Duration `json:"minimumBackoff,omitempty" yaml:"minimumBackoff,omitempty"`
+	MaximumBackoff Duration `json:"maximumBackoff,omitempty" yaml:"maximumBackoff,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string such as "30s" or
+// "168h" in topology documents.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(pd)
+	return nil
+}
+
+// LoadTopology reads and validates the YAML or JSON topology document at the
+// path.
+func LoadTopology(path string) (*Topology, error) {
+	dta, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTopology(dta)
+}
+
+// ParseTopology parses and validates a YAML or JSON topology document. Unknown
+// fields are rejected so that typos are not silently ignored.
+func ParseTopology(dta []byte) (*Topology, error) {
+	// JSON is a subset of YAML so a single decoder handles both
+	dec := yaml.NewDecoder(bytes.NewReader(dta))
+	dec.KnownFields(true)
+
+	t := &Topology{}
+	if err := dec.Decode(t); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid topology: %w", err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Validate checks that the topology is well formed. It does not check that
+// the topics it references exist.
+func (t *Topology) Validate() error {
+	if t.Name != "" && !labelValue.MatchString(t.Name) {
+		return fmt.Errorf("invalid topology: name %q may only contain lowercase letters, digits, underscores and dashes", t.Name)
+	}
+	if t.Prune && t.Name == "" {
+		return errors.New("invalid topology: a name is required to prune")
+	}
+
+	tids := make(map[string]bool)
+	for _, ts := range t.Topics {
+		if ts.ID == "" {
+			return errors.New("invalid topology: topic is missing an id")
+		}
+		if tids[ts.ID] {
+			return fmt.Errorf("invalid topology: topic %s is declared more than once", ts.ID)
+		}
+		if ts.Retention < 0 {
+			return fmt.Errorf("invalid topology: topic %s has a negative retention", ts.ID)
+		}
+
+		tids[ts.ID] = true
+	}
+
+	sids := make(map[string]bool)
+	for _, ss := range t.Subscriptions {
+		if ss.ID == "" {
+			return errors.New("invalid topology: subscription is missing an id")
+		}
+		if sids[ss.ID] {
+			return fmt.Errorf("invalid topology: subscription %s is declared more than once", ss.ID)
+		}
+		if err := ss.validate(); err != nil {
+			return fmt.Errorf("invalid topology: subscription %s %w", ss.ID, err)
+		}
+
+		sids[ss.ID] = true
+	}
+
+	return nil
+}
+
+func (s SubscriptionSpec) validate() error {
+	if s.Topic == "" {
+		return errors.New("is missing a topic")
+	}
+	if s.AckDeadline < 0 || s.Retention < 0 {
+		return errors.New("has a negative duration")
+	}
+	if s.Filter != "" {
+		if _, err := parseFilter(s.Filter); err != nil {
+			return fmt.Errorf("has an invalid filter: %w", err)
+		}
+	}
+
+	if dl := s.DeadLetter; dl != nil {
+		if dl.Topic == "" {
+			return errors.New("is missing a dead letter topic")
+		}
+		if !validDeliveryAttempts(dl.MaxDeliveryAttempts) {
+			return errors.New("must have between 5 and 100 max delivery attempts")
+		}
+	}
+
+	if r := s.Retry; r != nil {
+		if r.MinimumBackoff < 0 || r.MaximumBackoff < 0 {
+			return errors.New("has a negative backoff")
+		}
+		if r.MaximumBackoff != 0 && r.MinimumBackoff > r.MaximumBackoff {
+			return errors.New("has a minimum backoff greater than its maximum backoff")
+		}
+	}
+
+	return nil
+}
+
+// ActionType is the kind of change an Action makes.
+type ActionType string
+
+const (
+	// ActionCreate creates a missing topic or subscription.
+	ActionCreate ActionType = "create"
+
+	// ActionUpdate updates the configuration of an existing topic or
+	// subscription.
+	ActionUpdate ActionType = "update"
+
+	// ActionDrift reports configuration that differs from the topology but
+	// cannot be updated. Drift actions are never applied.
+	ActionDrift ActionType = "drift"
+
+	// ActionDelete deletes a topic or subscription that is no longer declared
+	// by a pruned topology.
+	ActionDelete ActionType = "delete"
+)
+
+// ResourceKind is the kind of resource an Action applies to.
+type ResourceKind string
+
+const (
+	ResourceTopic        ResourceKind = "topic"
+	ResourceSubscription ResourceKind = "subscription"
+)
+
+// Action is a single step of a Plan.
+type Action struct {
+	Changes []Change
+	ID      string
+	Kind    ResourceKind
+	Type    ActionType
+
+	apply func(context.Context) error
+}
+
+func (a Action) String() string {
+	s := fmt.Sprintf("%s %s %s", a.Type, a.Kind, a.ID)
+	if len(a.Changes) == 0 {
+		return s
+	}
+
+	cs := make([]string, len(a.Changes))
+	for i, c := range a.Changes {
+		cs[i] = c.String()
+	}
+
+	return s + ": " + strings.Join(cs, ", ")
+}
+
+// Plan is the ordered list of actions that reconciles a Backend with a
+// Topology. Topics are created and updated before subscriptions, and
+// subscriptions are deleted before topics.
+type Plan struct {
+	Actions []Action
+}
+
+// Drift returns the drift actions of the plan.
+func (p *Plan) Drift() []Action {
+	var drift []Action
+	for _, a := range p.Actions {
+		if a.Type == ActionDrift {
+			drift = append(drift, a)
+		}
+	}
+
+	return drift
+}
+
+func (p *Plan) String() string {
+	lines := make([]string, len(p.Actions))
+	for i, a := range p.Actions {
+		lines[i] = a.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Reconcile computes the plan that brings the Backend in line with the
+// topology and applies it. The plan is returned so that drift, which cannot be
+// applied, can be reported.
+func (p *PubSub) Reconcile(ctx context.Context, t *Topology) (*Plan, error) {
+	pl, err := p.Plan(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return pl, p.Apply(ctx, pl)
+}
+
+// Plan computes the actions that bring the Backend in line with the topology
+// without applying them.
+func (p *PubSub) Plan(ctx context.Context, t *Topology) (*Plan, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	pl := &Plan{}
+
+	tids := make(map[string]bool)
+	for _, ts := range t.Topics {
+		tids[ts.ID] = true
+
+		a, err := p.planTopic(ctx, t.Name, ts)
+		if err != nil {
+			return nil, err
+		}
+		if a != nil {
+			pl.Actions = append(pl.Actions, *a)
+		}
+	}
+
+	sids := make(map[string]bool)
+	for _, ss := range t.Subscriptions {
+		sids[ss.ID] = true
+
+		// the topics must be declared or already exist
+		for _, tid := range ss.topics() {
+			if err := p.ensureDeclared(ctx, tids, tid); err != nil {
+				return nil, fmt.Errorf("subscription %s: %w", ss.ID, err)
+			}
+		}
+
+		as, err := p.planSubscription(ctx, t.Name, ss)
+		if err != nil {
+			return nil, err
+		}
+
+		pl.Actions = append(pl.Actions, as...)
+	}
+
+	if t.Prune {
+		as, err := p.planPrune(ctx, t.Name, tids, sids)
+		if err != nil {
+			return nil, err
+		}
+
+		pl.Actions = append(pl.Actions, as...)
+	}
+
+	return pl, nil
+}
+
+// Apply applies the create, update and delete actions of the plan in order,
+// stopping at the first that fails.
+func (p *PubSub) Apply(ctx context.Context, pl *Plan) error {
+	for _, a := range pl.Actions {
+		if a.apply == nil {
+			continue
+		}
+
+		if err := a.apply(ctx); err != nil {
+			return fmt.Errorf("%s %s %s: %w", a.Type, a.Kind, a.ID, err)
+		}
+
+		p.log(ctx, slog.LevelInfo, "applied topology action", slog.String("action", string(a.Type)), slog.String("kind", string(a.Kind)), slog.String("id", a.ID))
+	}
+
+	return nil
+}
+
+func (p *PubSub) ensureDeclared(ctx context.Context, tids map[string]bool, tid string) error {
+	if tids[tid] {
+		return nil
+	}
+
+	exists, err := p.clnt.Topic(tid).Exists(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("topic %s is not declared and does not exist", tid)
+	}
+
+	return nil
+}
+
+func (p *PubSub) planTopic(ctx context.Context, name string, ts TopicSpec) (*Action, error) {
+	t := p.clnt.Topic(ts.ID)
+
+	exists, err := t.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lbls := topologyLabels(name, ts.Labels)
+	if !exists {
+		cfg := &pubsub.TopicConfig{Labels: lbls}
+		if ts.Retention > 0 {
+			cfg.RetentionDuration = time.Duration(ts.Retention)
+		}
+
+		return &Action{
+			ID:   ts.ID,
+			Kind: ResourceTopic,
+			Type: ActionCreate,
+			apply: func(ctx context.Context) error {
+				return p.clnt.CreateTopic(ctx, ts.ID, cfg)
+			},
+		}, nil
+	}
+
+	cur, err := t.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var chs []Change
+	upd := pubsub.TopicConfigToUpdate{}
+
+	if !labelsEqual(cur.Labels, lbls) {
+		chs = append(chs, Change{"labels", formatLabels(cur.Labels), formatLabels(lbls)})
+		upd.Labels = lbls
+	}
+
+	if cr := optionalDuration(cur.RetentionDuration); cr != time.Duration(ts.Retention) {
+		chs = append(chs, Change{"retention", cr.String(), time.Duration(ts.Retention).String()})
+
+		// a negative duration clears the retention
+		upd.RetentionDuration = time.Duration(ts.Retention)
+		if ts.Retention == 0 {
+			upd.RetentionDuration = time.Duration(-1)
+		}
+	}
+
+	if len(chs) == 0 {
+		return nil, nil
+	}
+
+	return &Action{
+		Changes: chs,
+		ID:      ts.ID,
+		Kind:    ResourceTopic,
+		Type:    ActionUpdate,
+		apply: func(ctx context.Context) error {
+			return t.Update(ctx, upd)
+		},
+	}, nil
+}
+
+func (p *PubSub) planSubscription(ctx context.Context, name string, ss SubscriptionSpec) ([]Action, error) {
+	s := p.clnt.Subscription(ss.ID)
+
+	exists, err := s.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	want := p.subscriptionConfig(name, ss)
+	if !exists {
+		return []Action{{
+			ID:   ss.ID,
+			Kind: ResourceSubscription,
+			Type: ActionCreate,
+			apply: func(ctx context.Context) error {
+				return p.clnt.CreateSubscription(ctx, ss.ID, ss.Topic, want)
+			},
+		}}, nil
+	}
+
+	cur, err := s.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the topic and filter cannot be updated once the subscription exists
+	var chs, drift []Change
+	for _, c := range compareSubscription(cur, ss.Topic, want) {
+		if immutableFields[c.Field] {
+			drift = append(drift, c)
+			continue
+		}
+
+		chs = append(chs, c)
+	}
+
+	var as []Action
+	if len(chs) > 0 {
+		upd := subscriptionUpdate(want, chs)
+		as = append(as, Action{
+			Changes: chs,
+			ID:      ss.ID,
+			Kind:    ResourceSubscription,
+			Type:    ActionUpdate,
+			apply: func(ctx context.Context) error {
+				return s.Update(ctx, upd)
+			},
+		})
+	}
+
+	if len(drift) > 0 {
+		as = append(as, Action{
+			Changes: drift,
+			ID:      ss.ID,
+			Kind:    ResourceSubscription,
+			Type:    ActionDrift,
+		})
+	}
+
+	return as, nil
+}
+
+// planPrune deletes the subscriptions and then the topics labelled with the
+// topology name that are no longer declared.
+func (p *PubSub) planPrune(ctx context.Context, name string, tids map[string]bool, sids map[string]bool) ([]Action, error) {
+	var as []Action
+
+	all, err := p.clnt.Subscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(all)
+
+	for _, sid := range all {
+		if sids[sid] {
+			continue
+		}
+
+		s := p.clnt.Subscription(sid)
+		cfg, err := s.Config(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Labels[TopologyLabel] == name {
+			as = append(as, Action{
+				ID:    sid,
+				Kind:  ResourceSubscription,
+				Type:  ActionDelete,
+				apply: s.Delete,
+			})
+		}
+	}
+
+	all, err = p.clnt.Topics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(all)
+
+	for _, tid := range all {
+		if tids[tid] {
+			continue
+		}
+
+		t := p.clnt.Topic(tid)
+		cfg, err := t.Config(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Labels[TopologyLabel] == name {
+			as = append(as, Action{
+				ID:    tid,
+				Kind:  ResourceTopic,
+				Type:  ActionDelete,
+				apply: t.Delete,
+			})
+		}
+	}
+
+	return as, nil
+}
+
+// topics returns the IDs of the topics the subscription depends on.
+func (s SubscriptionSpec) topics() []string {
+	tids := []string{s.Topic}
+	if s.DeadLetter != nil {
+		tids = append(tids, s.DeadLetter.Topic)
+	}
+
+	return tids
+}
+
+// subscriptionConfig returns the configuration declared by the spec with the
+// defaults of Google Cloud Pub/Sub applied, so that it can be compared with
+// the configuration of an existing subscription.
+func (p *PubSub) subscriptionConfig(name string, ss SubscriptionSpec) pubsub.SubscriptionConfig {
+	cfg := pubsub.SubscriptionConfig{
+		AckDeadline:         time.Duration(ss.AckDeadline),
+		Filter:              ss.Filter,
+		Labels:              topologyLabels(name, ss.Labels),
+		RetainAckedMessages: ss.RetainAcked,
+		RetentionDuration:   time.Duration(ss.Retention),
+	}
+
+	if dl := ss.DeadLetter; dl != nil {
+		cfg.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     p.clnt.Topic(dl.Topic).String(),
+			MaxDeliveryAttempts: dl.MaxDeliveryAttempts,
+		}
+	}
+
+	if r := ss.Retry; r != nil {
+		cfg.RetryPolicy = &pubsub.RetryPolicy{
+			MinimumBackoff: time.Duration(r.MinimumBackoff),
+			MaximumBackoff: time.Duration(r.MaximumBackoff),
+		}
+	}
+
+	return withSubscriptionDefaults(cfg)
+}
+
+// topologyLabels returns the labels with the topology label added when the
+// topology is named.
+func topologyLabels(name string, lbls map[string]string) map[string]string {
+	mgd := mergeMaps(lbls)
+	if name != "" {
+		mgd[TopologyLabel] = name
+	}
+
+	return mgd
+}
@@ -0,0 +1,194 @@
+package pb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testTopologyYAML = `
+name: orders-service
+prune: true
+topics:
+  - id: orders
+    retention: 24h
+  - id: orders-dlq
+subscriptions:
+  - id: orders-sub
+    topic: orders
+    filter: attributes.region = "CA"
+    ackDeadline: 30s
+    deadLetter:
+      topic: orders-dlq
+      maxDeliveryAttempts: 10
+    retry:
+      minimumBackoff: 5s
+`
+
+const testTopologyJSON = `{
+	"name": "orders-service",
+	"prune": true,
+	"topics": [{"id": "orders", "retention": "24h"}, {"id": "orders-dlq"}],
+	"subscriptions": [{
+		"id": "orders-sub",
+		"topic": "orders",
+		"filter": "attributes.region = \"CA\"",
+		"ackDeadline": "30s",
+		"deadLetter": {"topic": "orders-dlq", "maxDeliveryAttempts": 10},
+		"retry": {"minimumBackoff": "5s"}
+	}]
+}`
+
+func TestParseTopology(t *testing.T) {
+	want := &Topology{
+		Name:  "orders-service",
+		Prune: true,
+		Topics: []TopicSpec{
+			{ID: "orders", Retention: Duration(24 * time.Hour)},
+			{ID: "orders-dlq"},
+		},
+		Subscriptions: []SubscriptionSpec{{
+			ID:          "orders-sub",
+			Topic:       "orders",
+			Filter:      `attributes.region = "CA"`,
+			AckDeadline: Duration(30 * time.Second),
+			DeadLetter:  &DeadLetterSpec{Topic: "orders-dlq", MaxDeliveryAttempts: 10},
+			Retry:       &RetrySpec{MinimumBackoff: Duration(5 * time.Second)},
+		}},
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"should parse YAML", testTopologyYAML},
+		{"should parse JSON", testTopologyJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTopology([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("ParseTopology() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseTopology() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTopology_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"should reject unknown fields", "topics:\n  - id: orders\n    retension: 24h"},
+		{"should reject invalid durations", "topics:\n  - id: orders\n    retention: a day"},
+		{"should reject duplicate topics", "topics:\n  - id: orders\n  - id: orders"},
+		{"should reject subscriptions without a topic", "subscriptions:\n  - id: orders-sub"},
+		{"should reject invalid filters", "subscriptions:\n  - id: orders-sub\n    topic: orders\n    filter: region = CA"},
+		{"should reject too few delivery attempts", "subscriptions:\n  - id: orders-sub\n    topic: orders\n    deadLetter:\n      topic: dlq\n      maxDeliveryAttempts: 2"},
+		{"should reject inverted backoffs", "subscriptions:\n  - id: orders-sub\n    topic: orders\n    retry:\n      minimumBackoff: 1m\n      maximumBackoff: 1s"},
+		{"should reject pruning without a name", "prune: true"},
+		{"should reject names that are not valid labels", "name: Orders Service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTopology([]byte(tt.doc)); err == nil {
+				t.Error("ParseTopology() expected an error")
+			}
+		})
+	}
+}
+
+func TestPubSub_Reconcile(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ps *PubSub) {
+		ctx := context.Background()
+
+		top, err := ParseTopology([]byte(testTopologyYAML))
+		if err != nil {
+			t.Fatalf("ParseTopology() error = %v", err)
+		}
+
+		// resources that are not labelled with the topology are never pruned
+		if err := ps.CreateTopic("unmanaged"); err != nil {
+			t.Fatalf("CreateTopic() error = %v", err)
+		}
+
+		pl, err := ps.Reconcile(ctx, top)
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		assertActions(t, pl, []string{
+			"create topic orders",
+			"create topic orders-dlq",
+			"create subscription orders-sub",
+		})
+
+		// reconciling again should be a no-op
+		pl, err = ps.Plan(ctx, top)
+		if err != nil {
+			t.Fatalf("Plan() error = %v", err)
+		}
+		assertActions(t, pl, nil)
+
+		// change updatable and immutable fields, and drop a topic
+		top.Topics = top.Topics[:1]
+		top.Topics = append(top.Topics, TopicSpec{ID: "orders-retry"})
+		top.Subscriptions[0].AckDeadline = Duration(time.Minute)
+		top.Subscriptions[0].DeadLetter.Topic = "orders-retry"
+		top.Subscriptions[0].Filter = `attributes.region = "NY"`
+
+		pl, err = ps.Reconcile(ctx, top)
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		assertActions(t, pl, []string{
+			"create topic orders-retry",
+			"update subscription orders-sub: ackDeadline 30s -> 1m0s, deadLetter orders-dlq after 10 attempts -> orders-retry after 10 attempts",
+			`drift subscription orders-sub: filter "attributes.region = \"CA\"" -> "attributes.region = \"NY\""`,
+			"delete topic orders-dlq",
+		})
+		if len(pl.Drift()) != 1 {
+			t.Errorf("Drift() = %v, want 1 action", pl.Drift())
+		}
+
+		// only the drift should remain
+		pl, err = ps.Plan(ctx, top)
+		if err != nil {
+			t.Fatalf("Plan() error = %v", err)
+		}
+		assertActions(t, pl, []string{
+			`drift subscription orders-sub: filter "attributes.region = \"CA\"" -> "attributes.region = \"NY\""`,
+		})
+
+		if exists, _ := ps.clnt.Topic("unmanaged").Exists(ctx); !exists {
+			t.Error("Reconcile() should not prune unmanaged topics")
+		}
+	})
+}
+
+func TestPubSub_Plan_MissingTopic(t *testing.T) {
+	ps := newMemoryPubSub(t)
+
+	top := &Topology{
+		Subscriptions: []SubscriptionSpec{{ID: "orders-sub", Topic: "orders"}},
+	}
+	if _, err := ps.Plan(context.Background(), top); err == nil {
+		t.Error("Plan() expected an error for an undeclared topic")
+	}
+}
+
+func assertActions(t *testing.T, pl *Plan, want []string) {
+	t.Helper()
+
+	var got []string
+	for _, a := range pl.Actions {
+		got = append(got, a.String())
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan actions = %q, want %q", got, want)
+	}
+}
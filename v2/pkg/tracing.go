@@ -0,0 +1,127 @@
+package pb
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans started by PubSub.
+const tracerName = "github.com/clearchanneloutdoor/pubsub-go/v2"
+
+// traceContext propagates W3C trace context through the traceparent and
+// tracestate attributes.
+var traceContext = propagation.TraceContext{}
+
+// attributeCarrier adapts message attributes to a propagation.TextMapCarrier.
+type attributeCarrier map[string]string
+
+func (c attributeCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c attributeCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c attributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// TracePublish returns middleware that starts a producer span for every
+// published message, as a child of the span in the context, and injects it
+// into the traceparent and tracestate attributes of the message. The span ends
+// with a published event once the message has been sent, or with the error
+// that prevented it from being published.
+func TracePublish(tp trace.TracerProvider) PublishMiddleware {
+	tr := tp.Tracer(tracerName)
+
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, id string, m *pubsub.Message) PublishResult {
+			ctx, span := tr.Start(ctx, id+" publish",
+				trace.WithSpanKind(trace.SpanKindProducer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "gcp_pubsub"),
+					attribute.String("messaging.operation", "publish"),
+					attribute.String("messaging.destination.name", id),
+					attribute.Int("messaging.message.body.size", len(m.Data)),
+				),
+			)
+			if m.OrderingKey != "" {
+				span.SetAttributes(attribute.String("messaging.gcp_pubsub.message.ordering_key", m.OrderingKey))
+			}
+
+			traceContext.Inject(ctx, attributeCarrier(m.Attributes))
+
+			res := next(ctx, id, m)
+
+			// end the span once the message has been sent
+			onPublished(ctx, res, func() {
+				defer span.End()
+
+				mid, err := res.Get(context.Background())
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+					return
+				}
+
+				span.SetAttributes(attribute.String("messaging.message.id", mid))
+				span.AddEvent("published")
+			})
+
+			return res
+		}
+	}
+}
+
+// TraceReceive returns middleware that starts a consumer span for every
+// received message, linked to the producer span found in its traceparent and
+// tracestate attributes, and records an ack event when the handler succeeds or
+// a nack event along with the error when it fails.
+func TraceReceive(tp trace.TracerProvider) ReceiveMiddleware {
+	tr := tp.Tracer(tracerName)
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			opts := []trace.SpanStartOption{
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "gcp_pubsub"),
+					attribute.String("messaging.operation", "process"),
+					attribute.String("messaging.destination.subscription.name", m.Subscription),
+					attribute.String("messaging.message.id", m.ID),
+					attribute.Int("messaging.message.body.size", len(m.Data)),
+				),
+			}
+
+			// link to the producer span when the message carries one
+			pctx := traceContext.Extract(context.Background(), attributeCarrier(m.Attributes))
+			if sc := trace.SpanContextFromContext(pctx); sc.IsValid() {
+				opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+			}
+
+			ctx, span := tr.Start(ctx, m.Subscription+" process", opts...)
+			defer span.End()
+
+			if err := next(ctx, m); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				span.AddEvent("nack")
+				return err
+			}
+
+			span.AddEvent("ack")
+			return nil
+		}
+	}
+}
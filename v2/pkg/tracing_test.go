@@ -0,0 +1,137 @@
+package pb
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// waitForSpans returns the ended spans once there are n of them.
+func waitForSpans(t *testing.T, exp *tracetest.InMemoryExporter, n int) tracetest.SpanStubs {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if spans := exp.GetSpans(); len(spans) >= n {
+			return spans
+		}
+	}
+
+	t.Fatalf("%d spans ended, want %d", len(exp.GetSpans()), n)
+	return nil
+}
+
+// spansOfKind returns the spans of the kind.
+func spansOfKind(spans tracetest.SpanStubs, k trace.SpanKind) tracetest.SpanStubs {
+	var got tracetest.SpanStubs
+	for _, s := range spans {
+		if s.SpanKind == k {
+			got = append(got, s)
+		}
+	}
+
+	return got
+}
+
+// hasEvent reports whether the span recorded the event.
+func hasEvent(s tracetest.SpanStub, name string) bool {
+	for _, e := range s.Events {
+		if e.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPubSub_Tracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	ps := newMemoryPubSub(t)
+	ps.opts.SetTracerProvider(tp)
+
+	if err := ps.CreateTopic("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("topic", "sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	if err := ps.PublishContext(ctx, "topic", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	// fail the first delivery so that the message is nacked and redelivered
+	var attrs map[string]string
+	var calls atomic.Int32
+	receiveN(t, ps, "sub", 1, func(ctx context.Context, m *Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("failed")
+		}
+
+		attrs = m.Attributes
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			t.Error("handler context has no span")
+		}
+		return nil
+	})
+
+	if attrs["traceparent"] == "" {
+		t.Errorf("attributes = %v, want the traceparent attribute", attrs)
+	}
+
+	spans := waitForSpans(t, exp, 4)
+
+	producers := spansOfKind(spans, trace.SpanKindProducer)
+	if len(producers) != 1 {
+		t.Fatalf("%d producer spans, want 1", len(producers))
+	}
+	p := producers[0]
+	if p.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("producer span is not a child of the publishing span")
+	}
+	if !hasEvent(p, "published") {
+		t.Error("producer span has no published event")
+	}
+
+	consumers := spansOfKind(spans, trace.SpanKindConsumer)
+	if len(consumers) != 2 {
+		t.Fatalf("%d consumer spans, want 2", len(consumers))
+	}
+	for i, c := range consumers {
+		if len(c.Links) != 1 || c.Links[0].SpanContext.SpanID() != p.SpanContext.SpanID() {
+			t.Errorf("consumer span %d links = %v, want the producer span", i, c.Links)
+		}
+	}
+	if !hasEvent(consumers[0], "nack") {
+		t.Error("first consumer span has no nack event")
+	}
+	if !hasEvent(consumers[1], "ack") {
+		t.Error("second consumer span has no ack event")
+	}
+}
+
+func TestTraceReceive_WithoutTraceContext(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	h := TraceReceive(tp)(func(ctx context.Context, m *Message) error {
+		return nil
+	})
+	if err := h(context.Background(), &Message{ID: "1", Attributes: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 || len(spans[0].Links) != 0 {
+		t.Errorf("spans = %v, want one span without links", spans)
+	}
+}
@@ -0,0 +1,189 @@
+package pb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DecodeError describes a received message whose data could not be decoded
+// into the type expected by a TypedSubscriber.
+type DecodeError struct {
+	MessageID    string
+	Subscription string
+	Err          error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to decode message %s from subscription %s: %v", e.MessageID, e.Subscription, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TypedPublisher publishes values of type T to a single topic. Values are
+// marshalled with the PubSub's codec unless T is a []byte, in which case the
+// bytes are published as is, or a codec has been set on the publisher.
+type TypedPublisher[T any] struct {
+	codec Codec
+	id    string
+	ps    *PubSub
+}
+
+// NewTypedPublisher returns a TypedPublisher bound to the provided topic ID.
+func NewTypedPublisher[T any](ps *PubSub, id string) *TypedPublisher[T] {
+	return &TypedPublisher[T]{
+		id: id,
+		ps: ps,
+	}
+}
+
+// SetCodec sets the codec used to marshal published values and returns the
+// modified TypedPublisher.
+func (tp *TypedPublisher[T]) SetCodec(c Codec) *TypedPublisher[T] {
+	tp.codec = c
+	return tp
+}
+
+// Publish encodes the provided value and publishes it to the topic along with
+// any attributes that were provided.
+func (tp *TypedPublisher[T]) Publish(v T, attrs ...map[string]string) error {
+	return tp.ps.publish(tp.id, tp.codec, v, attrs...)
+}
+
+// TypedMessage is a received message whose data has been decoded into T.
+type TypedMessage[T any] struct {
+	ID          string
+	Data        T
+	Attributes  map[string]string
+	PublishTime time.Time
+
+	msg *Message
+}
+
+// Ack acknowledges the underlying message.
+func (m *TypedMessage[T]) Ack() {
+	m.msg.Ack()
+}
+
+// Nack negatively acknowledges the underlying message so that it is redelivered.
+func (m *TypedMessage[T]) Nack() {
+	m.msg.Nack()
+}
+
+// TypedSubscriber receives messages from a single subscription and decodes
+// them into values of type T. Messages that cannot be decoded are never
+// delivered to the caller; they are nacked by default, or published to a
+// dead-letter topic and acknowledged when one has been set.
+type TypedSubscriber[T any] struct {
+	dlt   string
+	id    string
+	onErr func(*DecodeError)
+	ps    *PubSub
+}
+
+// NewTypedSubscriber returns a TypedSubscriber bound to the provided
+// subscription ID.
+func NewTypedSubscriber[T any](ps *PubSub, id string) *TypedSubscriber[T] {
+	return &TypedSubscriber[T]{
+		id: id,
+		ps: ps,
+	}
+}
+
+// SetDeadLetterTopic sets the topic that messages which cannot be decoded are
+// published to and returns the modified TypedSubscriber. The original data and
+// attributes are preserved and a DecodeError attribute describing the failure
+// is added. When the dead-letter publish fails the message is nacked.
+func (ts *TypedSubscriber[T]) SetDeadLetterTopic(id string) *TypedSubscriber[T] {
+	ts.dlt = id
+	return ts
+}
+
+// SetDecodeErrorHandler sets a function that is called for every message that
+// cannot be decoded and returns the modified TypedSubscriber. The handler is
+// informational only; the message is nacked or dead-lettered regardless.
+func (ts *TypedSubscriber[T]) SetDecodeErrorHandler(f func(*DecodeError)) *TypedSubscriber[T] {
+	ts.onErr = f
+	return ts
+}
+
+// Receive subscribes to the subscription and passes decoded messages back to
+// the caller through the channel. The caller is responsible for calling Ack or
+// Nack on each message.
+func (ts *TypedSubscriber[T]) Receive(mc chan<- *TypedMessage[T]) error {
+	return ts.ps.receive(ts.ps.ctx, ts.id, func(ctx context.Context, m *Message) {
+		if tm, ok := ts.decode(m); ok {
+			mc <- tm
+		}
+	})
+}
+
+// ReceiveFunc subscribes to the subscription and calls the handler, wrapped
+// with the receive middleware, with each decoded message until the context is
+// done. Messages are acked when the handler returns nil and nacked when it
+// returns an error or panics.
+func (ts *TypedSubscriber[T]) ReceiveFunc(ctx context.Context, h func(context.Context, *TypedMessage[T]) error) error {
+	return ts.ps.receive(ctx, ts.id, func(ctx context.Context, msg *Message) {
+		tm, ok := ts.decode(msg)
+		if !ok {
+			return
+		}
+
+		// wrap the typed handler with the receive middleware
+		mh := ts.ps.receiveChain(ts.id, func(ctx context.Context, _ *Message) error { return h(ctx, tm) })
+		if err := handle(ctx, mh, msg); err != nil {
+			msg.Nack()
+			return
+		}
+
+		msg.Ack()
+	})
+}
+
+// decode converts the message into a TypedMessage, settling the message and
+// returning false when it cannot be decoded.
+func (ts *TypedSubscriber[T]) decode(m *Message) (*TypedMessage[T], bool) {
+	var v T
+	if err := m.Decode(&v); err != nil {
+		ts.handleDecodeError(m, err)
+		return nil, false
+	}
+
+	return &TypedMessage[T]{
+		ID:          m.ID,
+		Data:        v,
+		Attributes:  m.Attributes,
+		PublishTime: m.PublishTime,
+		msg:         m,
+	}, true
+}
+
+func (ts *TypedSubscriber[T]) handleDecodeError(m *Message, err error) {
+	de := &DecodeError{
+		MessageID:    m.ID,
+		Subscription: ts.id,
+		Err:          err,
+	}
+
+	// notify the caller of the failure
+	if ts.onErr != nil {
+		ts.onErr(de)
+	}
+
+	// nack the message when there is nowhere to dead-letter it
+	if ts.dlt == "" {
+		m.Nack()
+		return
+	}
+
+	// forward the message to the dead-letter topic
+	attrs := mergeMaps(m.Attributes, map[string]string{"DecodeError": err.Error()})
+	if err := ts.ps.Publish(ts.dlt, m.Data, attrs); err != nil {
+		m.Nack()
+		return
+	}
+
+	m.Ack()
+}
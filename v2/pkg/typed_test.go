@@ -0,0 +1,101 @@
+package pb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type typedExample struct {
+	CoolNumber int    `json:"coolNumber"`
+	Message    string `json:"message"`
+}
+
+func TestTypedSubscriber_Receive(t *testing.T) {
+	ps, _ := newTestPubSub(t)
+	if err := ps.CreateTopic("typed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("typed", "typed-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := NewTypedPublisher[typedExample](ps, "typed")
+	if err := pub.Publish(typedExample{13, "hello world"}, map[string]string{"region": "CA"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mc := make(chan *TypedMessage[typedExample])
+	go NewTypedSubscriber[typedExample](ps, "typed-sub").Receive(mc)
+
+	select {
+	case m := <-mc:
+		m.Ack()
+		if want := (typedExample{13, "hello world"}); !reflect.DeepEqual(m.Data, want) {
+			t.Errorf("Receive() = %v, want %v", m.Data, want)
+		}
+		if m.Attributes["region"] != "CA" {
+			t.Errorf("Receive() attributes = %v, want region CA", m.Attributes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestTypedSubscriber_DeadLetter(t *testing.T) {
+	ps, _ := newTestPubSub(t)
+	for _, id := range []string{"typed", "typed-dlq"} {
+		if err := ps.CreateTopic(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.CreateSubscription("typed", "typed-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.CreateSubscription("typed-dlq", "typed-dlq-sub", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// publish a payload that cannot be decoded
+	if err := ps.Publish("typed", []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan *DecodeError, 1)
+	ts := NewTypedSubscriber[typedExample](ps, "typed-sub").
+		SetDeadLetterTopic("typed-dlq").
+		SetDecodeErrorHandler(func(de *DecodeError) { errs <- de })
+
+	mc := make(chan *TypedMessage[typedExample])
+	go ts.Receive(mc)
+
+	select {
+	case de := <-errs:
+		var target *DecodeError
+		if !errors.As(de, &target) || de.Subscription != "typed-sub" {
+			t.Errorf("unexpected decode error %v", de)
+		}
+	case m := <-mc:
+		t.Fatalf("Receive() delivered undecodable message %v", m)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for decode error")
+	}
+
+	// the message should have been forwarded to the dead-letter topic
+	dlq := make(chan *TypedMessage[[]byte])
+	go NewTypedSubscriber[[]byte](ps, "typed-dlq-sub").Receive(dlq)
+
+	select {
+	case m := <-dlq:
+		m.Ack()
+		if string(m.Data) != "not json" {
+			t.Errorf("dead-lettered data = %s, want %s", m.Data, "not json")
+		}
+		if m.Attributes["DecodeError"] == "" {
+			t.Error("dead-lettered message is missing the DecodeError attribute")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dead-lettered message")
+	}
+}